@@ -0,0 +1,300 @@
+package assembler
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Assembler struct {
+	code     string
+	fileName string
+
+	symbols      map[string]int
+	nextVariable int
+}
+
+// An A-instruction holds a 15 bit address, and the ROM has room for as many
+// instructions as that can address
+const (
+	maxAddress = 32767
+	romSize    = maxAddress + 1
+)
+
+type instruction struct {
+	line int
+	text string
+}
+
+var predefinedSymbols = map[string]int{
+	"SP":     0,
+	"LCL":    1,
+	"ARG":    2,
+	"THIS":   3,
+	"THAT":   4,
+	"SCREEN": 16384,
+	"KBD":    24576,
+}
+
+// computations maps each comp mnemonic to its a-bit and six c-bits
+var computations = map[string]string{
+	"0":   "0101010",
+	"1":   "0111111",
+	"-1":  "0111010",
+	"D":   "0001100",
+	"A":   "0110000",
+	"M":   "1110000",
+	"!D":  "0001101",
+	"!A":  "0110001",
+	"!M":  "1110001",
+	"-D":  "0001111",
+	"-A":  "0110011",
+	"-M":  "1110011",
+	"D+1": "0011111",
+	"A+1": "0110111",
+	"M+1": "1110111",
+	"D-1": "0001110",
+	"A-1": "0110010",
+	"M-1": "1110010",
+	"D+A": "0000010",
+	"D+M": "1000010",
+	"D-A": "0010011",
+	"D-M": "1010011",
+	"A-D": "0000111",
+	"M-D": "1000111",
+	"D&A": "0000000",
+	"D&M": "1000000",
+	"D|A": "0010101",
+	"D|M": "1010101",
+}
+
+var jumps = map[string]string{
+	"":    "000",
+	"JGT": "001",
+	"JEQ": "010",
+	"JGE": "011",
+	"JLT": "100",
+	"JNE": "101",
+	"JLE": "110",
+	"JMP": "111",
+}
+
+func NewAssembler(code string, fileName ...string) *Assembler {
+	a := &Assembler{
+		code: code,
+	}
+
+	if len(fileName) > 0 {
+		a.fileName = fileName[0]
+	}
+
+	return a
+}
+
+// Assemble turns Hack assembly into .hack machine code, one 16 character
+// binary instruction per line
+func (a *Assembler) Assemble() (string, error) {
+	a.symbols = map[string]int{}
+	a.nextVariable = 16
+
+	for name, address := range predefinedSymbols {
+		a.symbols[name] = address
+	}
+	for i := 0; i < 16; i++ {
+		a.symbols["R"+strconv.Itoa(i)] = i
+	}
+
+	instructions, err := a.collectLabels()
+	if err != nil {
+		return "", err
+	}
+
+	var output strings.Builder
+	for _, instruction := range instructions {
+		var binary string
+		if strings.HasPrefix(instruction.text, "@") {
+			binary, err = a.assembleAddress(instruction)
+		} else {
+			binary, err = a.assembleCompute(instruction)
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		output.WriteString(binary)
+		output.WriteString("\n")
+	}
+
+	return output.String(), nil
+}
+
+func (a *Assembler) errorf(line int, format string, args ...interface{}) error {
+	if a.fileName != "" {
+		return fmt.Errorf("%s:%d: %s", a.fileName, line, fmt.Sprintf(format, args...))
+	}
+
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// collectLabels is the first pass. It strips comments and whitespace, and
+// records the address of every (LABEL), returning the remaining instructions.
+func (a *Assembler) collectLabels() ([]instruction, error) {
+	var instructions []instruction
+
+	scanner := bufio.NewScanner(strings.NewReader(a.code))
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := scanner.Text()
+		if comment := strings.Index(text, "//"); comment >= 0 {
+			text = text[:comment]
+		}
+
+		text = strings.Join(strings.Fields(text), "")
+		if text == "" {
+			continue
+		}
+
+		if !strings.HasPrefix(text, "(") {
+			if len(instructions) == romSize {
+				return nil, a.errorf(line, "program is longer than the %d instruction ROM", romSize)
+			}
+
+			instructions = append(instructions, instruction{line: line, text: text})
+			continue
+		}
+
+		if !strings.HasSuffix(text, ")") {
+			return nil, a.errorf(line, "unclosed label %s", text)
+		}
+
+		label := text[1 : len(text)-1]
+		if !isSymbol(label) {
+			return nil, a.errorf(line, "invalid label name %s", label)
+		}
+
+		if _, ok := a.symbols[label]; ok {
+			return nil, a.errorf(line, "label %s is already defined", label)
+		}
+
+		a.symbols[label] = len(instructions)
+	}
+
+	return instructions, scanner.Err()
+}
+
+// assembleAddress assembles an A-instruction, allocating a new variable for
+// any symbol that isn't a label or predefined
+func (a *Assembler) assembleAddress(instruction instruction) (string, error) {
+	value := instruction.text[1:]
+
+	address, err := strconv.Atoi(value)
+	switch {
+	case err == nil:
+		if address < 0 || address > maxAddress {
+			return "", a.errorf(instruction.line, "address %s out of range", value)
+		}
+
+	case isSymbol(value):
+		var ok bool
+		if address, ok = a.symbols[value]; !ok {
+			address = a.nextVariable
+			a.symbols[value] = address
+			a.nextVariable++
+		}
+
+		// A label just after the last instruction of a full ROM, or a
+		// variable allocated past the end of RAM, can't be addressed
+		if address > maxAddress {
+			return "", a.errorf(instruction.line, "address %d of %s out of range", address, value)
+		}
+
+	default:
+		return "", a.errorf(instruction.line, "invalid address %s", value)
+	}
+
+	return fmt.Sprintf("0%015b", address), nil
+}
+
+// assembleCompute assembles a C-instruction of the form dest=comp;jump, where
+// dest and jump are optional
+func (a *Assembler) assembleCompute(instruction instruction) (string, error) {
+	dest, comp, jump := "", instruction.text, ""
+
+	if i := strings.Index(comp, "="); i >= 0 {
+		dest, comp = comp[:i], comp[i+1:]
+	}
+	if i := strings.Index(comp, ";"); i >= 0 {
+		comp, jump = comp[:i], comp[i+1:]
+	}
+
+	destBits, ok := destination(dest)
+	if !ok {
+		return "", a.errorf(instruction.line, "invalid destination %s", dest)
+	}
+
+	compBits, ok := computations[comp]
+	if !ok {
+		compBits, ok = computations[commuted(comp)]
+	}
+	if !ok {
+		return "", a.errorf(instruction.line, "invalid computation %s", comp)
+	}
+
+	jumpBits, ok := jumps[jump]
+	if !ok || (jump == "" && strings.Contains(instruction.text, ";")) {
+		return "", a.errorf(instruction.line, "invalid jump %s", jump)
+	}
+
+	return "111" + compBits + destBits + jumpBits, nil
+}
+
+// destination returns the three destination bits, which may be named in any
+// order, so both AM and MA are accepted
+func destination(dest string) (string, bool) {
+	bits := []byte("000")
+
+	for _, r := range dest {
+		i := strings.IndexRune("ADM", r)
+		if i < 0 || bits[i] == '1' {
+			return "", false
+		}
+
+		bits[i] = '1'
+	}
+
+	return string(bits), true
+}
+
+// commuted swaps the operands of a commutative computation, so that A+D is
+// understood as D+A
+func commuted(comp string) string {
+	if len(comp) == 3 && strings.ContainsRune("+&|", rune(comp[1])) {
+		return comp[2:] + comp[1:2] + comp[:1]
+	}
+
+	return comp
+}
+
+// isSymbol reports whether s is a valid symbol: letters, digits, and _ . $ :
+// not starting with a digit
+func isSymbol(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("_.$:", r):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
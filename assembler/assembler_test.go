@@ -0,0 +1,377 @@
+package assembler
+
+import (
+	"fmt"
+	vmtranslator "liggi-go-jack-compiler/vm-translator"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAssembler_Instructions(t *testing.T) {
+	code := `// Computes R0 = 2 + 3
+@2
+D=A
+@3
+D=D+A   // commented
+@R0
+M=D
+(LOOP)
+@LOOP
+0;JMP
+@counter
+AM=M-1
+@other
+A+D;JGE
+@counter
+MD=!M
+`
+
+	expected := strings.Join([]string{
+		"0000000000000010",
+		"1110110000010000",
+		"0000000000000011",
+		"1110000010010000",
+		"0000000000000000",
+		"1110001100001000",
+		"0000000000000110",
+		"1110101010000111",
+		"0000000000010000",
+		"1111110010101000",
+		"0000000000010001",
+		"1110000010000011",
+		"0000000000010000",
+		"1111110001011000",
+	}, "\n") + "\n"
+
+	output, err := NewAssembler(code).Assemble()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff(expected, output); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestAssembler_Errors(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"@1\nD=X", "Prog.asm:2: invalid computation X"},
+		{"@1\nQ=D", "Prog.asm:2: invalid destination Q"},
+		{"@1\nMM=D", "Prog.asm:2: invalid destination MM"},
+		{"0;JUMP", "Prog.asm:1: invalid jump JUMP"},
+		{"@1\n\n@40000", "Prog.asm:3: address 40000 out of range"},
+		{"@1x", "Prog.asm:1: invalid address 1x"},
+		{"(LOOP)\n(LOOP)", "Prog.asm:2: label LOOP is already defined"},
+		{"(LOOP", "Prog.asm:1: unclosed label (LOOP"},
+	}
+
+	for _, test := range tests {
+		_, err := NewAssembler(test.code, "Prog.asm").Assemble()
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("expected error %q, got %v", test.expected, err)
+		}
+	}
+}
+
+func TestAssembler_Limits(t *testing.T) {
+	filler := func(n int) string {
+		return strings.Repeat("D=0\n", n)
+	}
+
+	variables := func(n int) string {
+		var code strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&code, "@v%d\n", i)
+		}
+		return code.String()
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+	}{
+		{"full ROM", filler(32768), ""},
+		{"too long", filler(32768) + "\n0;JMP", "Prog.asm:32770: program is longer than the 32768 instruction ROM"},
+		{"label past the end", "@END\n" + filler(32767) + "(END)", "Prog.asm:1: address 32768 of END out of range"},
+		{"every variable", variables(32752), ""},
+		{"too many variables", variables(32753), "Prog.asm:32753: address 32768 of v32752 out of range"},
+	}
+
+	for _, test := range tests {
+		_, err := NewAssembler(test.code, "Prog.asm").Assemble()
+
+		switch {
+		case test.expected == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		case test.expected != "" && (err == nil || err.Error() != test.expected):
+			t.Errorf("%s: expected error %q, got %v", test.name, test.expected, err)
+		}
+	}
+}
+
+// TestAssembler_TranslatedProgram assembles the output of the VM translator,
+// then runs the machine code to check the whole backend works together
+func TestAssembler_TranslatedProgram(t *testing.T) {
+	main := vmtranslator.File{Name: "Main", Code: `
+function Main.main 0
+push constant 6
+call Main.double 1
+push constant 1
+sub
+return
+function Main.double 0
+push argument 0
+push argument 0
+add
+return
+`}
+
+	ram := runTranslated(t, 10000, main)
+	if ram[256] != 11 {
+		t.Errorf("expected 11 at the bottom of the stack, got %d", ram[256])
+	}
+}
+
+// TestAssembler_TranslatedCalls runs a translated program with nested calls,
+// branches, and the pointer, static and local segments
+func TestAssembler_TranslatedCalls(t *testing.T) {
+	main := vmtranslator.File{Name: "Main", Code: `
+function Main.main 1
+push constant 5
+call Main.factorial 1
+pop local 0
+push constant 3
+push constant 7
+lt
+push constant 7
+push constant 3
+gt
+and
+push constant 4
+push constant 4
+eq
+and
+if-goto OK
+push constant 0
+return
+label OK
+push local 0
+call Helper.store 1
+pop temp 0
+push static 0
+push constant 0
+call Helper.load 1
+add
+return
+`}
+
+	// Main.factorial calls Math-free multiplication through repeated adds,
+	// and recurses, so calls and returns are nested several frames deep
+	factorial := vmtranslator.File{Name: "Main2", Code: `
+function Main.factorial 2
+push argument 0
+push constant 1
+gt
+if-goto RECURSE
+push constant 1
+return
+label RECURSE
+push argument 0
+push constant 1
+sub
+call Main.factorial 1
+pop local 0
+push constant 0
+pop local 1
+label LOOP
+push argument 0
+push constant 0
+eq
+if-goto DONE
+push local 1
+push local 0
+add
+pop local 1
+push argument 0
+push constant 1
+sub
+pop argument 0
+goto LOOP
+label DONE
+push local 1
+return
+`}
+
+	helper := vmtranslator.File{Name: "Helper", Code: `
+function Helper.store 0
+push constant 3000
+pop pointer 1
+push argument 0
+pop that 0
+push argument 0
+pop static 0
+push constant 0
+return
+function Helper.load 0
+push static 0
+push constant 3000
+pop pointer 0
+push this 0
+add
+return
+`}
+
+	ram := runTranslated(t, 100000, main, factorial, helper)
+
+	// Main.main returns 0 + 2 * 5!, which the bootstrap leaves at the bottom
+	// of the stack. Main's static 0 is separate from Helper's.
+	if ram[256] != 240 {
+		t.Errorf("expected 240 at the bottom of the stack, got %d", ram[256])
+	}
+}
+
+// TestAssembler_TranslatedComparisons checks the translator's lt and gt where
+// x - y would overflow, and eq, against the answers Go gives
+func TestAssembler_TranslatedComparisons(t *testing.T) {
+	values := []int16{-32768, -20000, -1, 0, 1, 20000, 32767}
+
+	// Each value is pushed as its magnitude, negated if need be. -32768 has
+	// no positive magnitude, so is -32767 - 1.
+	push := func(value int16) string {
+		switch {
+		case value == -32768:
+			return "push constant 32767\nneg\npush constant 1\nsub\n"
+		case value < 0:
+			return fmt.Sprintf("push constant %d\nneg\n", -value)
+		}
+		return fmt.Sprintf("push constant %d\n", value)
+	}
+
+	boolean := func(b bool) int16 {
+		if b {
+			return -1
+		}
+		return 0
+	}
+
+	for _, x := range values {
+		for _, y := range values {
+			for _, test := range []struct {
+				op       string
+				expected int16
+			}{
+				{"lt", boolean(x < y)},
+				{"gt", boolean(x > y)},
+				{"eq", boolean(x == y)},
+			} {
+				code := "function Main.main 0\n" + push(x) + push(y) + test.op + "\nreturn\n"
+
+				ram := runTranslated(t, 10000, vmtranslator.File{Name: "Main", Code: code})
+
+				if ram[256] != test.expected {
+					t.Errorf("%d %s %d: expected %d, got %d", x, test.op, y, test.expected, ram[256])
+				}
+			}
+		}
+	}
+}
+
+// runTranslated translates and assembles VM files, then runs the machine code,
+// so the translator's output can be checked by what it does
+func runTranslated(t *testing.T, steps int, files ...vmtranslator.File) []int16 {
+	t.Helper()
+
+	asm, err := vmtranslator.NewVMTranslator(files...).Translate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	hack, err := NewAssembler(asm).Assemble()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return run(t, hack, steps)
+}
+
+// run executes .hack machine code on a simple model of the Hack CPU
+func run(t *testing.T, hack string, steps int) []int16 {
+	t.Helper()
+
+	var rom []uint16
+	for _, line := range strings.Fields(hack) {
+		word, err := strconv.ParseUint(line, 2, 16)
+		if err != nil {
+			t.Fatalf("invalid machine code %s", line)
+		}
+
+		rom = append(rom, uint16(word))
+	}
+
+	ram := make([]int16, 32768)
+	var a, d int16
+	pc := 0
+
+	for ; steps > 0 && pc < len(rom); steps-- {
+		word := rom[pc]
+		pc++
+
+		if word&0x8000 == 0 {
+			a = int16(word)
+			continue
+		}
+
+		x, y := d, a
+		if word&0x1000 != 0 {
+			y = ram[uint16(a)]
+		}
+
+		// The ALU control bits zx, nx, zy, ny, f and no
+		control := word >> 6 & 0x3f
+		if control&0x20 != 0 {
+			x = 0
+		}
+		if control&0x10 != 0 {
+			x = ^x
+		}
+		if control&0x08 != 0 {
+			y = 0
+		}
+		if control&0x04 != 0 {
+			y = ^y
+		}
+
+		out := x & y
+		if control&0x02 != 0 {
+			out = x + y
+		}
+		if control&0x01 != 0 {
+			out = ^out
+		}
+
+		address := a
+		if word&0x08 != 0 {
+			ram[uint16(address)] = out
+		}
+		if word&0x20 != 0 {
+			a = out
+		}
+		if word&0x10 != 0 {
+			d = out
+		}
+
+		jump := (word&0x04 != 0 && out < 0) || (word&0x02 != 0 && out == 0) || (word&0x01 != 0 && out > 0)
+		if jump {
+			pc = int(uint16(address))
+		}
+	}
+
+	return ram
+}
@@ -2,7 +2,12 @@ package codegenerator
 
 import (
 	"fmt"
+	"liggi-go-jack-compiler/diagnostic"
+	"liggi-go-jack-compiler/semantic"
 	"liggi-go-jack-compiler/token"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 type CodeGenerator struct {
@@ -12,6 +17,31 @@ type CodeGenerator struct {
 	whileStatementCount   int
 	ifStatementCount      int
 	className             string
+	options               Options
+
+	spans             []token.Span
+	sourceMap         SourceMap
+	instructionsSaved int
+}
+
+type Options struct {
+	// Annotate puts a comment before each statement's code giving its
+	// position in the Jack source, and the statement itself, which is taken
+	// from Source
+	Annotate bool
+	Source   string
+
+	// SourceMap records where in the Jack source each line of VM code came
+	// from, which can be read with CodeGenerator.SourceMap after generating
+	SourceMap bool
+
+	// Optimise runs the peephole optimiser over the generated code. It's
+	// off by default so that code matches what the reference compiler makes.
+	Optimise bool
+
+	// FoldConstants works out expressions made of constants, like `7 - 3`,
+	// at compile time, pushing just their value
+	FoldConstants bool
 }
 
 type Symbol struct {
@@ -43,12 +73,18 @@ type Statement struct {
 	typ string
 }
 
-func NewCodeGenerator(code []token.Node) *CodeGenerator {
-	return &CodeGenerator{
+func NewCodeGenerator(code []token.Node, options ...Options) *CodeGenerator {
+	c := &CodeGenerator{
 		code:                  code,
 		classSymbolTable:      NewSymbolTable(),
 		subroutineSymbolTable: NewSymbolTable(),
 	}
+
+	if len(options) > 0 {
+		c.options = options[0]
+	}
+
+	return c
 }
 
 var CharacterMap = map[rune]int{
@@ -147,6 +183,33 @@ var CharacterMap = map[rune]int{
 	'|':  124,
 	'}':  125,
 	'~':  126,
+
+	// Keys with no printable character of their own
+	128: 128, // newline
+	129: 129, // backspace
+	130: 130, // left arrow
+	131: 131, // up arrow
+	132: 132, // right arrow
+	133: 133, // down arrow
+	134: 134, // home
+	135: 135, // end
+	136: 136, // page up
+	137: 137, // page down
+	138: 138, // insert
+	139: 139, // delete
+	140: 140, // escape
+	141: 141, // F1
+	142: 142, // F2
+	143: 143, // F3
+	144: 144, // F4
+	145: 145, // F5
+	146: 146, // F6
+	147: 147, // F7
+	148: 148, // F8
+	149: 149, // F9
+	150: 150, // F10
+	151: 151, // F11
+	152: 152, // F12
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -332,63 +395,16 @@ func (c *CodeGenerator) compileExpressionTerm(term *token.Element) (string, erro
 	return compiledExpression, nil
 }
 
-func (c *CodeGenerator) compileFunctionCallTerm(term *token.Element) (string, error) {
-	var code string
-	var numArgs int
-
-	qualifierToken, err := term.ChildAsToken(0)
-	if err != nil {
-		return "", err
-	}
-
-	qualifier := qualifierToken.Value
-	subroutineName, err := term.ChildAsToken(2)
-	if err != nil {
-		return "", err
-	}
-
-	instanceVar := c.findSymbol(qualifier)
-
-	if (instanceVar != Symbol{}) {
-		code += instanceVar.Push()
-
-		qualifier = instanceVar.Type
-		numArgs = 1
-	}
-
-	if qualifier == "" {
-		code += "push pointer 0\n"
-
-		qualifier = c.className
-		numArgs = 1
-	}
-
-	expressionList := term.FindElement("expressionList")
-	expressions := expressionList.AllChildElementsByTag("expression")
-
-	args := len(expressions)
-	compiledExpressionList, _, err := c.compileExpressionList(*expressionList)
-	if err != nil {
-		return "", err
-	}
-
-	code += compiledExpressionList
-
-	numArgs += args
+func (c *CodeGenerator) compileString(s *token.Token) (string, error) {
+	chars := []rune(s.Value)
 
-	code += fmt.Sprintf("call %s.%s %d\n", qualifier, subroutineName.Value, numArgs)
-
-	return code, nil
-}
-
-func (c *CodeGenerator) compileString(s string) (string, error) {
-	code := fmt.Sprintf("push constant %d\n", len(s))
+	code := fmt.Sprintf("push constant %d\n", len(chars))
 	code += "call String.new 1\n"
 
-	for _, char := range s {
+	for _, char := range chars {
 		charCode, ok := CharacterMap[char]
 		if !ok {
-			return "", fmt.Errorf("unknown character: %s", string(char))
+			return "", diagnostic.Errorf(s.Span(), "unsupported character %q in string", char)
 		}
 
 		code += fmt.Sprintf("push constant %d\n", charCode)
@@ -407,7 +423,7 @@ func (c *CodeGenerator) compileTerm(term *token.Element) (string, error) {
 	// If the expression is a function call, we compile the expression list
 	// call the function, then return
 	if term.FindElement("expressionList") != nil {
-		return c.compileFunctionCallTerm(term)
+		return c.compileSubroutineCall(term)
 	}
 
 	token, err := term.ChildAsToken(0)
@@ -418,10 +434,14 @@ func (c *CodeGenerator) compileTerm(term *token.Element) (string, error) {
 	// Otherwise, it's just a simple push operation
 	switch token.TokenType {
 	case "integerConstant":
+		if d := semantic.IntegerRangeError(token); d != nil {
+			return "", *d
+		}
+
 		return "push constant " + token.Value + "\n", nil
 
 	case "stringConstant":
-		return c.compileString(token.Value)
+		return c.compileString(token)
 
 	case "keyword":
 		switch token.Value {
@@ -436,105 +456,207 @@ func (c *CodeGenerator) compileTerm(term *token.Element) (string, error) {
 		}
 
 	case "identifier":
-		ident := c.findSymbol(token.Value)
+		ident, err := c.lookupVariable(token)
+		if err != nil {
+			return "", err
+		}
+
 		return ident.Push(), nil
 	}
 
 	return "", nil
 }
 
-func (c *CodeGenerator) compileExpression(expression *token.Element) (string, error) {
-	var expressionCode string
+// compileExpressionOperand compiles one of the terms an expression is made of
+func (c *CodeGenerator) compileExpressionOperand(term *token.Element) (string, error) {
+	code, err := c.compileOperand(term)
+	if err != nil {
+		return "", err
+	}
 
-	terms := expression.AllChildElementsByTag("term")
+	return c.spanned(term, code), nil
+}
 
-	for _, term := range terms {
-		// Is the term an array access?
-		if term.FindChildToken("symbol", "[") != nil {
-			identifier, err := term.ChildAsToken(0)
-			if err != nil {
-				return "", err
-			}
+func (c *CodeGenerator) compileOperand(term *token.Element) (string, error) {
+	var code string
 
-			ident := c.findSymbol(identifier.Value)
+	if c.options.FoldConstants {
+		if value, ok := constantTerm(term); ok {
+			return pushConstant(value), nil
+		}
+	}
 
-			arrayAccessExpression := term.FindElement("expression")
-			compiledExpression, err := c.compileExpression(arrayAccessExpression)
-			if err != nil {
-				return "", err
-			}
+	// Is the term an array access?
+	if term.FindChildToken("symbol", "[") != nil {
+		identifier, err := term.ChildAsToken(0)
+		if err != nil {
+			return "", err
+		}
 
-			expressionCode += compiledExpression
-			expressionCode += ident.Push()
-			expressionCode += "add\n"
-			expressionCode += "pop pointer 1\n"
-			expressionCode += "push that 0\n"
+		ident, err := c.lookupVariable(identifier)
+		if err != nil {
+			return "", err
+		}
 
-			continue
+		arrayAccessExpression := term.FindElement("expression")
+		compiledExpression, err := c.compileExpression(arrayAccessExpression)
+		if err != nil {
+			return "", err
 		}
 
-		// Is the term another expression?
-		termExpression := term.FindChildElement("expression")
-		if termExpression != nil {
-			compiledExpression, err := c.compileExpression(termExpression)
-			if err != nil {
-				return "", err
-			}
+		code += compiledExpression
+		code += ident.Push()
+		code += "add\n"
+		code += "pop pointer 1\n"
+		code += "push that 0\n"
 
-			expressionCode += compiledExpression
+		return code, nil
+	}
 
-			continue
+	// Is the term another expression?
+	termExpression := term.FindChildElement("expression")
+	if termExpression != nil {
+		return c.compileExpression(termExpression)
+	}
+
+	// Is it a unary operation?
+	unaryOp, _ := term.ChildAsToken(0)
+	if unaryOp != nil && isUnaryOperation(unaryOp.Value) {
+		termToCompile, err := term.ChildAsElement(1)
+		if err != nil {
+			return "", err
 		}
 
-		// Is it a unary operation?
-		unaryOp, _ := term.ChildAsToken(0)
-		if isUnaryOperation(unaryOp.Value) {
-			termToCompile, err := term.ChildAsElement(1)
-			if err != nil {
-				return "", err
+		if unaryOp.Value == "-" && semantic.IsMinimumInteger(termToCompile) {
+			return pushConstant(-32768), nil
+		}
+
+		// The operand can itself be any term, including another unary
+		// operation, as in ~-5
+		compiledTerm, err := c.compileOperand(termToCompile)
+		if err != nil {
+			return "", err
+		}
+
+		code += c.spanned(termToCompile, compiledTerm)
+		op, err := unaryOpToCode(unaryOp.Value)
+		if err != nil {
+			return "", err
+		}
+
+		code += op + "\n"
+
+		return code, nil
+	}
+
+	// Otherwise, it's just a regular term
+	return c.compileTerm(term)
+}
+
+func (c *CodeGenerator) compileExpression(expression *token.Element) (string, error) {
+	code, err := c.compileExpressionTerms(expression)
+	if err != nil {
+		return "", err
+	}
+
+	return c.spanned(expression, code), nil
+}
+
+func (c *CodeGenerator) compileExpressionTerms(expression *token.Element) (string, error) {
+	var expressionCode string
+	var pendingOp *token.Token
+
+	// Any constant terms at the start are pushed as one value, and skipped
+	// along with the operators between them
+	folded, terms := 0, 0
+	if c.options.FoldConstants {
+		var value int16
+		if value, folded = constantPrefix(expression); folded > 1 {
+			expressionCode = pushConstant(value)
+		} else {
+			folded = 0
+		}
+	}
+
+	// Expressions are `term (op term)*`, evaluated left to right, so each
+	// operation is emitted as soon as the term to its right has been pushed
+	for _, child := range expression.Children {
+		switch child := child.(type) {
+		case *token.Token:
+			if child.TokenType == "symbol" && terms >= folded {
+				pendingOp = child
 			}
 
-			compiledTerm, err := c.compileTerm(termToCompile)
-			if err != nil {
-				return "", err
+		case *token.Element:
+			if child.Tag != "term" {
+				continue
 			}
 
-			expressionCode += compiledTerm
-			op, err := unaryOpToCode(unaryOp.Value)
+			terms++
+			if terms <= folded {
+				continue
+			}
+
+			compiledTerm, err := c.compileExpressionOperand(child)
 			if err != nil {
 				return "", err
 			}
 
-			expressionCode += op + "\n"
+			expressionCode += compiledTerm
 
-			continue
-		}
+			if pendingOp != nil {
+				operation, err := opToCode(pendingOp.Value)
+				if err != nil {
+					return "", err
+				}
 
-		// Otherwise, it's just a regular term
-		compiledTerm, err := c.compileTerm(term)
-		if err != nil {
-			return "", err
+				expressionCode += operation + "\n"
+				pendingOp = nil
+			}
 		}
+	}
+
+	return expressionCode, nil
+}
 
-		expressionCode += compiledTerm
+// annotation returns a comment like `// Main.jack:42  let x = y + 1;` for a
+// statement. Only the header of an if or while is included, not its body.
+func (c *CodeGenerator) annotation(statement *token.Element) string {
+	span := statement.Span()
+	if !span.Start.IsValid() {
+		return ""
 	}
 
-	// Handle the operation, if there is one!
-	op := expression.FindChildToken("symbol")
-	if op != nil {
-		operation, err := opToCode(op.Value)
-		if err != nil {
-			return "", err
-		}
+	location := strconv.Itoa(span.Start.Line)
+	if span.Start.File != "" {
+		location = filepath.Base(span.Start.File) + ":" + location
+	}
 
-		expressionCode += operation + "\n"
+	var text string
+	if span.Start.Offset < span.End.Offset && span.End.Offset <= len(c.options.Source) {
+		text = c.options.Source[span.Start.Offset:span.End.Offset]
 	}
 
-	return expressionCode, nil
+	// Only the header of an if or while is shown, up to the { of its body.
+	// Looking for the symbol, rather than in the text, skips any { in a
+	// string in the condition.
+	if statement.Tag == "ifStatement" || statement.Tag == "whileStatement" {
+		if body := statement.FindChildToken("symbol", "{"); body != nil {
+			if end := body.Start.Offset - span.Start.Offset; end >= 0 && end <= len(text) {
+				text = text[:end]
+			}
+		}
+	}
+
+	return fmt.Sprintf("// %s  %s\n", location, strings.Join(strings.Fields(text), " "))
 }
 
 func (c *CodeGenerator) compileStatement(statement token.Element) (string, error) {
-	var code string
+	code := c.beginSpan(&statement)
+
+	if c.options.Annotate {
+		code += c.annotation(&statement)
+	}
 
 	switch statement.Tag {
 	case "letStatement":
@@ -574,7 +696,7 @@ func (c *CodeGenerator) compileStatement(statement token.Element) (string, error
 		code += compiledIf
 	}
 
-	return code, nil
+	return code + c.endSpan(), nil
 }
 
 func (c *CodeGenerator) compileIfStatement(ifStatement token.Element) (string, error) {
@@ -733,9 +855,9 @@ func (c *CodeGenerator) compileLetStatement(letStatement token.Element) (string,
 		return "", err
 	}
 
-	symbol := c.findSymbol(identifier.Value)
-	if (symbol == Symbol{}) {
-		return "", fmt.Errorf("symbol (%s) not found", identifier.Value)
+	symbol, err := c.lookupVariable(identifier)
+	if err != nil {
+		return "", err
 	}
 
 	if hasLeftHandArrayAssignment {
@@ -768,29 +890,87 @@ func (c *CodeGenerator) compileLetStatement(letStatement token.Element) (string,
 	return code, nil
 }
 
-func getDoStatement(doStatement token.Element) (string, string, error) {
-	hasQualifier := doStatement.FindChildToken("symbol", ".") != nil
+// subroutineCall is the target of a `name(...)` or `qualifier.name(...)` call
+type subroutineCall struct {
+	qualifier *token.Token
+	name      *token.Token
+	arguments *token.Element
+}
 
-	if !hasQualifier {
-		subroutineName, err := doStatement.ChildAsToken(1)
-		if err != nil {
-			return "", "", err
+func getSubroutineCall(call *token.Element) (subroutineCall, error) {
+	var identifiers []*token.Token
+	var target subroutineCall
+
+	for _, child := range call.Children {
+		switch child := child.(type) {
+		case *token.Token:
+			if child.TokenType == "identifier" && target.arguments == nil {
+				identifiers = append(identifiers, child)
+			}
+		case *token.Element:
+			if child.Tag == "expressionList" {
+				target.arguments = child
+			}
 		}
+	}
 
-		return "", subroutineName.Value, nil
+	switch {
+	case target.arguments == nil:
+		return target, fmt.Errorf("expected expression list in subroutine call")
+	case len(identifiers) == 1:
+		target.name = identifiers[0]
+	case len(identifiers) == 2:
+		target.qualifier = identifiers[0]
+		target.name = identifiers[1]
+	default:
+		return target, fmt.Errorf("unexpected subroutine call with %d identifiers", len(identifiers))
 	}
 
-	qualifier, err := doStatement.ChildAsToken(1)
+	return target, nil
+}
+
+func (c *CodeGenerator) compileSubroutineCall(call *token.Element) (string, error) {
+	var code string
+	var numArgs int
+
+	target, err := getSubroutineCall(call)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 
-	subroutineName, err := doStatement.ChildAsToken(3)
+	var qualifier string
+	if target.qualifier != nil {
+		qualifier = target.qualifier.Value
+	}
+
+	instanceVar := c.findSymbol(qualifier)
+
+	if (instanceVar != Symbol{}) {
+		code += instanceVar.Push()
+
+		qualifier = instanceVar.Type
+		numArgs = 1
+	}
+
+	if qualifier == "" {
+		code += "push pointer 0\n"
+
+		qualifier = c.className
+		numArgs = 1
+	}
+
+	compiledExpressionList, argCount, err := c.compileExpressionList(*target.arguments)
 	if err != nil {
-		return "", "", err
+		return "", err
 	}
 
-	return qualifier.Value, subroutineName.Value, nil
+	code += compiledExpressionList
+
+	numArgs += argCount
+
+	code += fmt.Sprintf("call %s.%s %d\n", qualifier, target.name.Value, numArgs)
+
+	return code, nil
 }
 
 func (c *CodeGenerator) findSymbol(obj string) Symbol {
@@ -803,6 +983,17 @@ func (c *CodeGenerator) findSymbol(obj string) Symbol {
 	return symbol
 }
 
+// lookupVariable finds the symbol a variable name refers to. Undeclared
+// names are reported by the checker, so one turning up here is a bug.
+func (c *CodeGenerator) lookupVariable(name *token.Token) (Symbol, error) {
+	symbol := c.findSymbol(name.Value)
+	if (symbol == Symbol{}) {
+		return Symbol{}, diagnostic.Errorf(name.Span(), "internal error: no symbol for variable %s, which should have been checked", name.Value)
+	}
+
+	return symbol, nil
+}
+
 func (c *CodeGenerator) compileExpressionList(expressionList token.Element) (string, int, error) {
 	expressions := expressionList.AllChildElementsByTag("expression")
 	if len(expressions) == 0 {
@@ -824,43 +1015,11 @@ func (c *CodeGenerator) compileExpressionList(expressionList token.Element) (str
 }
 
 func (c *CodeGenerator) compileDoStatement(doStatement token.Element) (string, error) {
-	var code string
-	var numArgs int
-
-	qualifier, subroutine, err := getDoStatement(doStatement)
+	code, err := c.compileSubroutineCall(&doStatement)
 	if err != nil {
 		return "", err
 	}
 
-	instanceVar := c.findSymbol(qualifier)
-
-	if (instanceVar != Symbol{}) {
-		code += instanceVar.Push()
-
-		qualifier = instanceVar.Type
-		numArgs = 1
-	}
-
-	if qualifier == "" {
-		code += "push pointer 0\n"
-
-		qualifier = c.className
-		numArgs = 1
-	}
-
-	expressionList := doStatement.FindChildElement("expressionList")
-
-	compiledExpressionList, argCount, err := c.compileExpressionList(*expressionList)
-	if err != nil {
-		return "", err
-	}
-
-	code += compiledExpressionList
-
-	numArgs += argCount
-
-	code += fmt.Sprintf("call %s.%s %d\n", qualifier, subroutine, numArgs)
-
 	// Do statements don't have a return value, so just dump it
 	code += "pop temp 0\n"
 
@@ -932,7 +1091,8 @@ func (c *CodeGenerator) compileSubroutine(class, dec *token.Element) (string, er
 	className := class.FindChildToken("identifier").Value
 	funcName := subroutine.name
 
-	code := fmt.Sprintf("function %s.%s %d\n", className, funcName, numLocalVars)
+	code := c.beginSpan(dec)
+	code += fmt.Sprintf("function %s.%s %d\n", className, funcName, numLocalVars)
 
 	if subroutine.subroutineType == Constructor {
 		// If it's a constructor, allocate memory for the object
@@ -956,7 +1116,7 @@ func (c *CodeGenerator) compileSubroutine(class, dec *token.Element) (string, er
 		code += compiledStatement
 	}
 
-	return code, nil
+	return code + c.endSpan(), nil
 }
 
 func (c *CodeGenerator) compileClass(class *token.Element) (string, error) {
@@ -1019,6 +1179,9 @@ func (c *CodeGenerator) initSymbolTable(s *SymbolTable, vars []*token.Element) (
 func (c *CodeGenerator) Generate() (string, error) {
 	var code string
 
+	c.spans = nil
+	c.sourceMap = SourceMap{}
+
 	for _, child := range c.code {
 		switch child := child.(type) {
 		case *token.Element:
@@ -1035,5 +1198,20 @@ func (c *CodeGenerator) Generate() (string, error) {
 		}
 	}
 
+	c.instructionsSaved = 0
+	if c.options.Optimise {
+		code, c.instructionsSaved = Optimise(code)
+	}
+
+	if c.options.SourceMap {
+		return c.resolveSourceMap(code)
+	}
+
 	return code, nil
 }
+
+// InstructionsSaved returns how many instructions the peephole optimiser
+// removed from the code last generated
+func (c *CodeGenerator) InstructionsSaved() int {
+	return c.instructionsSaved
+}
@@ -2,19 +2,31 @@ package codegenerator
 
 import (
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
+	"liggi-go-jack-compiler/diagnostic"
+	jackos "liggi-go-jack-compiler/jack-os"
 	"liggi-go-jack-compiler/parser"
+	"liggi-go-jack-compiler/semantic"
+	"liggi-go-jack-compiler/token"
 	"liggi-go-jack-compiler/tokeniser"
+	vminterpreter "liggi-go-jack-compiler/vm-interpreter"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 )
 
+// Run `go test ./code-generator -run TestJackFiles -update -v` to regenerate the
+// golden files and see which changed
+var update = flag.Bool("update", false, "rewrite the golden .vm files in test-cases from the current code generator output")
+
 func loadTestCases(path string) ([]string, error) {
 	var testCases []string
 	err := filepath.Walk(path, func(currPath string, info fs.FileInfo, err error) error {
@@ -56,26 +68,68 @@ func generateVMFileFromJack(jackPath string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to open .jack file %s: %v", jackPath, err)
 	}
+	defer file.Close()
+
+	_, generated, err := generateVM(file, jackPath, Options{})
+	return generated, err
+}
 
-	tokeniser := tokeniser.NewTokeniser(file)
+// generateVM compiles a .jack file, named path, with the given options. The
+// code generator is returned too, for what it records while generating.
+func generateVM(r io.Reader, path string, options Options) (*CodeGenerator, string, error) {
+	tokeniser := tokeniser.NewTokeniser(r, path)
 	tokens, tokeniserErr := tokeniser.Tokenise()
 	if tokeniserErr != nil {
-		return "", fmt.Errorf("tokeniser error: %v", tokeniserErr)
+		return nil, "", fmt.Errorf("tokeniser error: %w", tokeniserErr)
 	}
 
 	parser := parser.NewParser(tokens)
 	syntax, parserErr := parser.Parse()
 	if parserErr != nil {
-		return "", fmt.Errorf("parser error: %v", parserErr)
+		return nil, "", fmt.Errorf("parser error: %w", parserErr)
 	}
 
-	codeGenerator := NewCodeGenerator(syntax)
+	codeGenerator := NewCodeGenerator(syntax, options)
 	generated, generatorErr := codeGenerator.Generate()
 	if generatorErr != nil {
-		return "", fmt.Errorf("code generator error: %v", generatorErr)
+		return nil, "", fmt.Errorf("code generator error: %w", generatorErr)
 	}
 
-	return generated, nil
+	return codeGenerator, generated, nil
+}
+
+func testGenerate(t *testing.T, source string, expected string) {
+	_, generated, err := generateVM(strings.NewReader(source), "Main.jack", Options{})
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+
+	if diff := cmp.Diff(strings.Split(expected, "\n"), strings.Split(generated, "\n")); diff != "" {
+		t.Errorf("mismatch in generated code (-expected +got):\n%s", diff)
+	}
+}
+
+func TestOperatorChain(t *testing.T) {
+	source := `
+class Main {
+	function int main() {
+		var int a, b;
+		return a * 2 + b - 1;
+	}
+}`
+
+	expected := `function Main.main 2
+push local 0
+push constant 2
+call Math.multiply 2
+push local 1
+add
+push constant 1
+sub
+return
+`
+
+	testGenerate(t, source, expected)
 }
 
 func TestJackFiles(t *testing.T) {
@@ -116,6 +170,11 @@ func TestJackFiles(t *testing.T) {
 
 				expectedVM := string(expectedVMBytes)
 
+				if *update {
+					updateGoldenFile(t, vmPath, expectedVM, generatedVM)
+					continue
+				}
+
 				if expectedVM != generatedVM {
 					expectedVMSlice := strings.Split(expectedVM, "\n")
 					generatedVMSlice := strings.Split(generatedVM, "\n")
@@ -130,3 +189,517 @@ func TestJackFiles(t *testing.T) {
 		}
 	}
 }
+
+// jackSource is a .jack file to compile, named by its path
+type jackSource struct {
+	path string
+	code string
+}
+
+// compileProgram checks several .jack files together, as the CLI does, then
+// compiles them without writing any .vm files
+func compileProgram(options Options, sources ...jackSource) ([]vminterpreter.File, error) {
+	var syntaxes [][]token.Node
+	registry := semantic.NewRegistry()
+
+	for _, source := range sources {
+		tokens, err := tokeniser.NewTokeniser(strings.NewReader(source.code), source.path).Tokenise()
+		if err != nil {
+			return nil, err
+		}
+
+		syntax, err := parser.NewParser(tokens).Parse()
+		if err != nil {
+			return nil, err
+		}
+
+		if diagnostics := registry.Collect(syntax); len(diagnostics) > 0 {
+			return nil, diagnostics
+		}
+
+		syntaxes = append(syntaxes, syntax)
+	}
+
+	checker := semantic.NewChecker(registry)
+	for _, syntax := range syntaxes {
+		if diagnostics := checker.Check(syntax); diagnostics.HasErrors() {
+			return nil, diagnostics
+		}
+	}
+
+	var files []vminterpreter.File
+	for i, syntax := range syntaxes {
+		generated, err := NewCodeGenerator(syntax, options).Generate()
+		if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimSuffix(filepath.Base(sources[i].path), ".jack")
+		files = append(files, vminterpreter.File{Name: name, Code: generated})
+	}
+
+	return files, nil
+}
+
+// readOptional reads a file that a test case may leave out
+func readOptional(t *testing.T, path string) (string, bool) {
+	t.Helper()
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false
+	}
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+
+	return string(contents), true
+}
+
+// readRAM reads lines of "address value" pairs
+func readRAM(t *testing.T, path string, contents string) map[int]int16 {
+	t.Helper()
+
+	ram := map[int]int16{}
+	for i, line := range strings.Split(contents, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 2 {
+			t.Fatalf("%s:%d: expected an address and a value", path, i+1)
+		}
+
+		address, err := strconv.Atoi(fields[0])
+		if err != nil || address < 0 || address > 32767 {
+			t.Fatalf("%s:%d: invalid address %s", path, i+1, fields[0])
+		}
+
+		value, err := strconv.ParseInt(fields[1], 10, 16)
+		if err != nil {
+			t.Fatalf("%s:%d: invalid value %s", path, i+1, fields[1])
+		}
+
+		ram[address] = int16(value)
+	}
+
+	return ram
+}
+
+// TestJackPrograms compiles and runs every test case with an
+// expected_output.txt, checking what the program does rather than the exact
+// code generated for it. A test case may also have:
+//
+//   - keyboard_input.txt, typed into the program as it runs
+//   - initial_ram.txt, "address value" lines to set before it runs
+//   - expected_ram.txt, "address value" lines to check once it's finished
+func TestJackPrograms(t *testing.T) {
+	testCaseDirs, err := loadTestCases("../test-cases")
+	if err != nil {
+		t.Fatalf("failed to load test cases: %v", err)
+	}
+
+	for _, testCaseDir := range testCaseDirs {
+		expectedOutput, ok := readOptional(t, filepath.Join(testCaseDir, "expected_output.txt"))
+		if !ok {
+			continue
+		}
+
+		t.Run(filepath.Base(testCaseDir), func(t *testing.T) {
+			testJackProgram(t, testCaseDir, expectedOutput, Options{})
+		})
+
+		t.Run(filepath.Base(testCaseDir)+"/optimised", func(t *testing.T) {
+			testJackProgram(t, testCaseDir, expectedOutput, Options{Optimise: true, FoldConstants: true})
+		})
+	}
+}
+
+func testJackProgram(t *testing.T, testCaseDir, expectedOutput string, options Options) {
+	jackFiles, err := findJackFiles(testCaseDir)
+	if err != nil {
+		t.Fatalf("failed to find .jack files in %s: %v", testCaseDir, err)
+	}
+
+	var sources []jackSource
+	for _, jackPath := range jackFiles {
+		code, err := os.ReadFile(jackPath)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", jackPath, err)
+		}
+		sources = append(sources, jackSource{jackPath, string(code)})
+	}
+
+	files, err := compileProgram(options, sources...)
+	if err != nil {
+		t.Fatalf("failed to compile %s: %v", testCaseDir, err)
+	}
+
+	keyboard, _ := readOptional(t, filepath.Join(testCaseDir, "keyboard_input.txt"))
+
+	interpreter := vminterpreter.NewInterpreter(files...)
+	interpreter.MaxSteps = 10000000
+
+	if contents, ok := readOptional(t, filepath.Join(testCaseDir, "initial_ram.txt")); ok {
+		for address, value := range readRAM(t, "initial_ram.txt", contents) {
+			interpreter.RAM[address] = value
+		}
+	}
+
+	jackOS := jackos.New(strings.NewReader(keyboard))
+	jackOS.Install(interpreter)
+
+	if err := interpreter.Run(); err != nil {
+		t.Fatalf("failed to run %s: %v\noutput so far:\n%s", testCaseDir, err, jackOS.Output())
+	}
+
+	// The file's final newline is there for tidiness, not expected
+	expectedOutput = strings.TrimSuffix(expectedOutput, "\n")
+	if diff := cmp.Diff(strings.Split(expectedOutput, "\n"), strings.Split(jackOS.Output(), "\n")); diff != "" {
+		t.Errorf("mismatch in output of %s (-expected +got):\n%s", testCaseDir, diff)
+	}
+
+	if contents, ok := readOptional(t, filepath.Join(testCaseDir, "expected_ram.txt")); ok {
+		for address, value := range readRAM(t, "expected_ram.txt", contents) {
+			if interpreter.RAM[address] != value {
+				t.Errorf("expected RAM[%d] to be %d, got %d", address, value, interpreter.RAM[address])
+			}
+		}
+	}
+}
+
+// updateGoldenFile rewrites a .vm file if the generated code has changed, and
+// logs how many lines were added and removed, which go test shows with -v
+func updateGoldenFile(t *testing.T, vmPath, expectedVM, generatedVM string) {
+	t.Helper()
+
+	if expectedVM == generatedVM {
+		return
+	}
+
+	if err := os.WriteFile(vmPath, []byte(generatedVM), 0644); err != nil {
+		t.Fatalf("failed to update .vm file %s: %v", vmPath, err)
+	}
+
+	added, removed := lineDelta(strings.Split(expectedVM, "\n"), strings.Split(generatedVM, "\n"))
+	t.Logf("updated %s: +%d -%d lines", vmPath, added, removed)
+}
+
+// lineDelta counts the lines added and removed between two versions of a
+// file, using the longest common subsequence of their lines
+func lineDelta(before, after []string) (added, removed int) {
+	common := make([][]int, len(before)+1)
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			switch {
+			case before[i] == after[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] > common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	unchanged := common[0][0]
+	return len(after) - unchanged, len(before) - unchanged
+}
+
+func TestLineDelta(t *testing.T) {
+	before := []string{"push constant 1", "push constant 2", "add", "return"}
+	after := []string{"push constant 1", "push constant 3", "add", "neg", "return"}
+
+	added, removed := lineDelta(before, after)
+	if added != 2 || removed != 1 {
+		t.Errorf("expected +2 -1, got +%d -%d", added, removed)
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	source := `class Main {
+	function void main() {
+		var int i;
+		while (i <
+		       10) {
+			let i = i
+			      + 1;
+		}
+		return;
+	}
+}`
+
+	_, generated, err := generateVM(strings.NewReader(source), "src/Main.jack", Options{Annotate: true, Source: source})
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+
+	expected := `function Main.main 1
+// Main.jack:4  while (i < 10)
+label WHILE_EXP0
+push local 0
+push constant 10
+lt
+not
+if-goto WHILE_END0
+// Main.jack:6  let i = i + 1;
+push local 0
+push constant 1
+add
+pop local 0
+goto WHILE_EXP0
+label WHILE_END0
+// Main.jack:9  return;
+push constant 0
+return
+`
+
+	if diff := cmp.Diff(strings.Split(expected, "\n"), strings.Split(generated, "\n")); diff != "" {
+		t.Errorf("mismatch in generated code (-expected +got):\n%s", diff)
+	}
+}
+
+func TestAnnotate_BraceInCondition(t *testing.T) {
+	source := `class Main {
+	function void main() {
+		var String s;
+		while (s = "{") { let s = "}"; }
+		return;
+	}
+}`
+
+	_, generated, err := generateVM(strings.NewReader(source), "Main.jack", Options{Annotate: true, Source: source})
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+
+	expected := `// Main.jack:4  while (s = "{")`
+	if !strings.Contains(generated, expected+"\n") {
+		t.Errorf("expected the annotation %q, got:\n%s", expected, generated)
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	source := `class Main {
+	function int main() {
+		var int i;
+		while (i < 3) {
+			let i = i + 1;
+		}
+		return i;
+	}
+}`
+
+	codeGenerator, generated, err := generateVM(strings.NewReader(source), "Main.jack", Options{SourceMap: true})
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+
+	// The markers used to build the map must not leak into the code
+	_, plain, _ := generateVM(strings.NewReader(source), "Main.jack", Options{})
+	if generated != plain {
+		t.Errorf("expected the same code with and without a source map, got:\n%s", generated)
+	}
+
+	at := func(line, start, end int) Mapping {
+		return Mapping{File: "Main.jack", StartLine: line, StartColumn: start, EndLine: line, EndColumn: end}
+	}
+
+	subroutine := Mapping{File: "Main.jack", StartLine: 2, StartColumn: 2, EndLine: 8, EndColumn: 3}
+	while := Mapping{File: "Main.jack", StartLine: 4, StartColumn: 3, EndLine: 6, EndColumn: 4}
+	let := at(5, 4, 18)
+	ret := at(7, 3, 12)
+
+	// Each line maps to the innermost node that produced it: the terms
+	// push, the expressions operate, and the statements do the rest
+	lines := []Mapping{
+		subroutine,
+		while, at(4, 10, 11), at(4, 14, 15), at(4, 10, 15), while, while,
+		at(5, 12, 13), at(5, 16, 17), at(5, 12, 17), let,
+		while, while,
+		at(7, 10, 11), ret,
+	}
+
+	var expected []Mapping
+	for i, mapping := range lines {
+		mapping.VMLine = i
+		expected = append(expected, mapping)
+	}
+
+	if diff := cmp.Diff(expected, codeGenerator.SourceMap().Mappings); diff != "" {
+		t.Errorf("mismatch in source map (-expected +got):\n%s", diff)
+	}
+
+	// A call maps to its own term, inside the unary operation on it
+	call := "class Main { function int main() { return -Main.f(2); } }"
+	codeGenerator, _, err = generateVM(strings.NewReader(call), "Main.jack", Options{SourceMap: true})
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+
+	expected = nil
+	for i, mapping := range []Mapping{at(1, 14, 56), at(1, 51, 52), at(1, 44, 53), at(1, 43, 53), at(1, 36, 54)} {
+		mapping.VMLine = i
+		expected = append(expected, mapping)
+	}
+
+	if diff := cmp.Diff(expected, codeGenerator.SourceMap().Mappings); diff != "" {
+		t.Errorf("mismatch in source map for a call (-expected +got):\n%s", diff)
+	}
+}
+
+func TestIntegerRange(t *testing.T) {
+	source := `class Main {
+	function int main() {
+		return -32768;
+	}
+}`
+
+	expected := `function Main.main 0
+push constant 32767
+not
+return
+`
+
+	testGenerate(t, source, expected)
+
+	var d diagnostic.Diagnostic
+	_, _, err := generateVM(strings.NewReader("class Main { function int main() { return 40000; } }"), "Main.jack", Options{})
+	if !errors.As(err, &d) || d.Error() != "Main.jack:1:43: error: integer constant 40000 is out of range 0..32767" {
+		t.Errorf("expected an out of range error, got %v", err)
+	}
+}
+
+// A unary operation's operand can be another unary operation, which has to
+// be compiled in full rather than as a plain term
+func TestNestedUnaryOperations(t *testing.T) {
+	source := `class Main {
+	function int main() {
+		var int x;
+		let x = ~-5;
+		return - -32768;
+	}
+}`
+
+	expected := `function Main.main 1
+push constant 5
+neg
+not
+pop local 0
+push constant 32767
+not
+neg
+return
+`
+
+	testGenerate(t, source, expected)
+}
+
+func TestStringEscapes(t *testing.T) {
+	source := `class Main {
+	function void main() {
+		do Output.printString("\"a\"\n\x82");
+		return;
+	}
+}`
+
+	expected := `function Main.main 0
+push constant 5
+call String.new 1
+push constant 34
+call String.appendChar 2
+push constant 97
+call String.appendChar 2
+push constant 34
+call String.appendChar 2
+push constant 128
+call String.appendChar 2
+push constant 130
+call String.appendChar 2
+call Output.printString 1
+pop temp 0
+push constant 0
+return
+`
+
+	testGenerate(t, source, expected)
+}
+
+// An int used as a condition is true for any value but 0, which the
+// optimiser has to keep to
+func TestOptimisedIntCondition(t *testing.T) {
+	source := `class Main {
+	function void main() {
+		var int x;
+		let x = 1;
+		if (x) { do Output.printInt(1); } else { do Output.printInt(2); }
+		if (~x) { do Output.printInt(3); } else { do Output.printInt(4); }
+		return;
+	}
+}`
+
+	for _, options := range []Options{{}, {Optimise: true, FoldConstants: true}} {
+		_, generated, err := generateVM(strings.NewReader(source), "Main.jack", options)
+		if err != nil {
+			t.Fatalf("failed to generate code: %v", err)
+		}
+
+		interpreter := vminterpreter.NewInterpreter(vminterpreter.File{Name: "Main", Code: generated})
+		jackOS := jackos.New(nil)
+		jackOS.Install(interpreter)
+
+		if err := interpreter.Run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if output := jackOS.Output(); output != "13" {
+			t.Errorf("expected 13 with %+v, got %q", options, output)
+		}
+	}
+}
+
+func TestStaticAndCharVariables(t *testing.T) {
+	source := `
+class Counter {
+	static int count;
+	static char last;
+
+	function char bump(char c) {
+		let count = count + 1;
+		let last = c;
+		return last;
+	}
+}`
+
+	expected := `function Counter.bump 0
+push static 0
+push constant 1
+add
+pop static 0
+push argument 0
+pop static 1
+push static 1
+return
+`
+
+	testGenerate(t, source, expected)
+}
+
+func TestUndeclaredVariable(t *testing.T) {
+	source := `class Main {
+	function int main() {
+		var int x;
+		return x + y;
+	}
+}`
+
+	_, _, err := generateVM(strings.NewReader(source), "Main.jack", Options{})
+	if err == nil || !strings.Contains(err.Error(), "Main.jack:4:14: error: internal error: no symbol for variable y") {
+		t.Errorf("expected missing symbol error, got %v", err)
+	}
+}
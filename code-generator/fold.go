@@ -0,0 +1,161 @@
+package codegenerator
+
+import (
+	"fmt"
+	"liggi-go-jack-compiler/semantic"
+	"liggi-go-jack-compiler/token"
+	"strconv"
+)
+
+// constantTerm works out the value of a term made only of constants, such as
+// `-(2 * 4)` or `~false`. Arithmetic wraps around at 16 bits, as it does on
+// the Hack platform.
+func constantTerm(term *token.Element) (int16, bool) {
+	first, err := term.ChildAsToken(0)
+	if err != nil {
+		return 0, false
+	}
+
+	switch first.TokenType {
+	case "integerConstant":
+		value, err := strconv.Atoi(first.Value)
+		if err != nil || value > semantic.MaxInteger {
+			return 0, false
+		}
+		return int16(value), true
+
+	case "keyword":
+		switch first.Value {
+		case "true":
+			return -1, true
+		case "false", "null":
+			return 0, true
+		}
+
+	case "symbol":
+		if expression := term.FindChildElement("expression"); expression != nil && first.Value == "(" {
+			value, folded := constantPrefix(expression)
+			return value, folded == len(expression.AllChildElementsByTag("term"))
+		}
+
+		operand := term.FindChildElement("term")
+		if operand == nil {
+			return 0, false
+		}
+
+		if first.Value == "-" && semantic.IsMinimumInteger(operand) {
+			return -32768, true
+		}
+
+		value, ok := constantTerm(operand)
+		if !ok {
+			return 0, false
+		}
+
+		switch first.Value {
+		case "-":
+			return -value, true
+		case "~":
+			return ^value, true
+		}
+	}
+
+	return 0, false
+}
+
+// constantPrefix folds as many terms from the start of an expression as are
+// constant. Jack evaluates strictly left to right, so a constant prefix can
+// be folded even if the rest of the expression isn't constant. It returns
+// the value and the number of terms folded.
+func constantPrefix(expression *token.Element) (int16, int) {
+	var value int16
+	var pendingOp string
+	folded := 0
+
+	for _, child := range expression.Children {
+		switch child := child.(type) {
+		case *token.Token:
+			pendingOp = child.Value
+
+		case *token.Element:
+			if child.Tag != "term" {
+				continue
+			}
+
+			termValue, ok := constantTerm(child)
+			if !ok {
+				return value, folded
+			}
+
+			if folded == 0 {
+				value = termValue
+			} else {
+				// An operation that can't be folded, such as division by
+				// zero, ends the prefix without losing what it holds
+				result, ok := applyOperator(pendingOp, value, termValue)
+				if !ok {
+					return value, folded
+				}
+
+				value = result
+			}
+
+			folded++
+		}
+	}
+
+	return value, folded
+}
+
+func applyOperator(op string, x, y int16) (int16, bool) {
+	switch op {
+	case "+":
+		return x + y, true
+	case "-":
+		return x - y, true
+	case "*":
+		return x * y, true
+	case "/":
+		// Division by zero is left for the OS to report when it happens
+		if y == 0 {
+			return 0, false
+		}
+		return x / y, true
+	case "&":
+		return x & y, true
+	case "|":
+		return x | y, true
+	case "<":
+		return boolean(x < y), true
+	case ">":
+		return boolean(x > y), true
+	case "=":
+		return boolean(x == y), true
+	}
+
+	return 0, false
+}
+
+func boolean(b bool) int16 {
+	if b {
+		return -1
+	}
+
+	return 0
+}
+
+// pushConstant pushes any 16-bit value. The constant segment only holds
+// 0..32767, so negative values are pushed as their magnitude and negated.
+// -1 and -32768 are pushed as `not` of 0 and 32767 instead, which is how
+// true is written, so the optimiser still recognises it, and is shorter than
+// -32767 - 1 for -32768, whose magnitude doesn't fit.
+func pushConstant(value int16) string {
+	switch {
+	case value >= 0:
+		return fmt.Sprintf("push constant %d\n", value)
+	case value == -1 || value == -32768:
+		return fmt.Sprintf("push constant %d\nnot\n", ^value)
+	}
+
+	return fmt.Sprintf("push constant %d\nneg\n", -value)
+}
@@ -0,0 +1,91 @@
+package codegenerator
+
+import (
+	"liggi-go-jack-compiler/parser"
+	"liggi-go-jack-compiler/tokeniser"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{"7 - 3", "push constant 4"},
+		{"-(2 * 4)", "push constant 8\nneg"},
+		{"100 / -7", "push constant 14\nneg"},
+		{"32767 + 1", "push constant 32767\nnot"},
+		{"300 * 300", "push constant 24464"},
+		{"(1 < 2) & ~false", "push constant 0\nnot"},
+		{"null = 0", "push constant 0\nnot"},
+		// Only the constant start of an expression can be folded, since
+		// Jack has no operator precedence
+		{"2 * 3 + x", "push constant 6\npush local 0\nadd"},
+		{"x + 2 * 3", "push local 0\npush constant 2\nadd\npush constant 3\ncall Math.multiply 2"},
+		{"x * (2 + 3)", "push local 0\npush constant 5\ncall Math.multiply 2"},
+		// Division by zero is left to fail when the program runs
+		{"1 / 0", "push constant 1\npush constant 0\ncall Math.divide 2"},
+		{"6 / 2 / 0", "push constant 3\npush constant 0\ncall Math.divide 2"},
+		{"-x", "push local 0\nneg"},
+	}
+
+	for _, test := range tests {
+		source := "class Main { function int main() { var int x; return " + test.expression + "; } }"
+
+		tokens, err := tokeniser.NewTokeniser(strings.NewReader(source)).Tokenise()
+		if err != nil {
+			t.Fatalf("failed to tokenise %q: %v", test.expression, err)
+		}
+
+		syntax, err := parser.NewParser(tokens).Parse()
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", test.expression, err)
+		}
+
+		generated, err := NewCodeGenerator(syntax, Options{FoldConstants: true}).Generate()
+		if err != nil {
+			t.Fatalf("failed to generate code for %q: %v", test.expression, err)
+		}
+
+		expected := "function Main.main 1\n" + test.expected + "\nreturn\n"
+		if diff := cmp.Diff(strings.Split(expected, "\n"), strings.Split(generated, "\n")); diff != "" {
+			t.Errorf("mismatch in code for %q (-expected +got):\n%s", test.expression, diff)
+		}
+	}
+}
+
+// Folding true has to leave it in a form the optimiser still knows, or
+// folding would make optimised code worse
+func TestFoldConstants_Optimised(t *testing.T) {
+	source := `class Main {
+	function void main() {
+		var int x;
+		while (true) {
+			let x = x + 1;
+			if (false) { let x = 0; }
+		}
+		return;
+	}
+}`
+
+	optimised, expected, err := generateVM(strings.NewReader(source), "Main.jack", Options{Optimise: true})
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+
+	folded, generated, err := generateVM(strings.NewReader(source), "Main.jack", Options{Optimise: true, FoldConstants: true})
+	if err != nil {
+		t.Fatalf("failed to generate code: %v", err)
+	}
+
+	if diff := cmp.Diff(strings.Split(expected, "\n"), strings.Split(generated, "\n")); diff != "" {
+		t.Errorf("expected the same code with and without folding (-expected +got):\n%s", diff)
+	}
+
+	if folded.InstructionsSaved() < optimised.InstructionsSaved() {
+		t.Errorf("expected folding to save at least %d instructions, saved %d", optimised.InstructionsSaved(), folded.InstructionsSaved())
+	}
+}
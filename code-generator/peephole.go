@@ -0,0 +1,189 @@
+package codegenerator
+
+import (
+	"strings"
+)
+
+// Optimise runs a peephole pass over generated VM code, rewriting short
+// sequences of instructions into shorter ones that do the same thing. It
+// returns the optimised code and the number of instructions saved.
+//
+// Comments, and anything else that isn't an instruction, are left where they
+// are, and never stop a pattern from matching.
+func Optimise(code string) (string, int) {
+	lines := strings.SplitAfter(code, "\n")
+	before := countInstructions(lines)
+
+	for {
+		var rewritten, removed bool
+		lines, rewritten = rewrite(lines)
+		lines, removed = removeUnusedLabels(lines)
+
+		if !rewritten && !removed {
+			break
+		}
+	}
+
+	return strings.Join(lines, ""), before - countInstructions(lines)
+}
+
+func isInstruction(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed != "" && !strings.HasPrefix(trimmed, "//") && !strings.HasPrefix(line, "\x00")
+}
+
+func countInstructions(lines []string) int {
+	count := 0
+	for _, line := range lines {
+		if isInstruction(line) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// rewrite makes one pass over the code, applying each pattern wherever it
+// matches
+func rewrite(lines []string) ([]string, bool) {
+	var instructions []int
+	for i, line := range lines {
+		if isInstruction(line) {
+			instructions = append(instructions, i)
+		}
+	}
+
+	fields := func(k int) []string {
+		if k >= len(instructions) {
+			return nil
+		}
+		return strings.Fields(lines[instructions[k]])
+	}
+
+	is := func(k int, op string, args ...string) bool {
+		f := fields(k)
+		if len(f) != len(args)+1 || f[0] != op {
+			return false
+		}
+		for i, arg := range args {
+			if arg != "" && f[i+1] != arg {
+				return false
+			}
+		}
+		return true
+	}
+
+	// isBoolean reports whether instruction k leaves 0 or -1 on the stack:
+	// a comparison or false, and not of either of those
+	isBoolean := func(k int) bool {
+		for k >= 0 && is(k, "not") {
+			k--
+		}
+
+		return k >= 0 && (is(k, "eq") || is(k, "lt") || is(k, "gt") || is(k, "push", "constant", "0"))
+	}
+
+	var output []string
+	changed := false
+	next := 0
+
+	// replace swaps the instructions k to k+n-1 for new ones, keeping any
+	// other lines among them
+	replace := func(k, n int, replacement ...string) {
+		output = append(output, lines[next:instructions[k]]...)
+		for _, instruction := range replacement {
+			output = append(output, instruction+"\n")
+		}
+
+		last := instructions[k+n-1]
+		for i := instructions[k]; i <= last; i++ {
+			if !isInstruction(lines[i]) {
+				output = append(output, lines[i])
+			}
+		}
+
+		next = last + 1
+		changed = true
+	}
+
+	for k := 0; k < len(instructions); k++ {
+		switch {
+		// not; not does nothing
+		case is(k, "not") && is(k+1, "not"):
+			replace(k, 2)
+			k++
+
+		// A jump over an unconditional jump is the same as jumping on the
+		// opposite condition. if-goto jumps on anything but 0, while not is
+		// bitwise, so this only holds when the condition is known to be 0
+		// or -1.
+		case is(k, "if-goto", "") && is(k+1, "goto", "") && is(k+2, "label", fields(k)[1]) && isBoolean(k-1):
+			replace(k, 2, "not", "if-goto "+fields(k + 1)[1])
+			k++
+
+		// false never jumps, true always does
+		case is(k, "push", "constant", "0") && is(k+1, "if-goto", ""):
+			replace(k, 2)
+			k++
+
+		case is(k, "push", "constant", "0") && is(k+1, "not") && is(k+2, "if-goto", ""):
+			replace(k, 3, "goto "+fields(k + 2)[1])
+			k += 2
+
+		// Jumping to the next instruction is the same as carrying on
+		case is(k, "goto", "") && is(k+1, "label", fields(k)[1]):
+			replace(k, 1)
+
+		// Nothing after a jump or return can run until the next label
+		case (is(k, "goto", "") || is(k, "return")) && k+1 < len(instructions) && !is(k+1, "label", "") && !is(k+1, "function", "", ""):
+			end := k + 1
+			for end < len(instructions) && !is(end, "label", "") && !is(end, "function", "", "") {
+				end++
+			}
+
+			kept := lines[instructions[k]]
+			replace(k, end-k, strings.TrimSpace(kept))
+			k = end - 1
+		}
+	}
+
+	output = append(output, lines[next:]...)
+	return output, changed
+}
+
+// removeUnusedLabels removes labels that nothing jumps to. Labels are scoped
+// to the function they're in, so each function is looked at separately.
+func removeUnusedLabels(lines []string) ([]string, bool) {
+	var output []string
+	changed := false
+
+	start := 0
+	for start < len(lines) {
+		end := start + 1
+		for end < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[end]), "function ") {
+			end++
+		}
+
+		used := map[string]bool{}
+		for _, line := range lines[start:end] {
+			f := strings.Fields(line)
+			if len(f) == 2 && (f[0] == "goto" || f[0] == "if-goto") && isInstruction(line) {
+				used[f[1]] = true
+			}
+		}
+
+		for _, line := range lines[start:end] {
+			f := strings.Fields(line)
+			if len(f) == 2 && f[0] == "label" && isInstruction(line) && !used[f[1]] {
+				changed = true
+				continue
+			}
+
+			output = append(output, line)
+		}
+
+		start = end
+	}
+
+	return output, changed
+}
@@ -0,0 +1,179 @@
+package codegenerator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOptimise(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     string
+		expected string
+		saved    int
+	}{
+		{
+			name: "if jumps over a goto",
+			code: `function Main.f 0
+push argument 0
+push constant 1
+lt
+if-goto IF_TRUE0
+goto IF_FALSE0
+label IF_TRUE0
+push constant 1
+return
+label IF_FALSE0
+push constant 2
+return
+`,
+			expected: `function Main.f 0
+push argument 0
+push constant 1
+lt
+not
+if-goto IF_FALSE0
+push constant 1
+return
+label IF_FALSE0
+push constant 2
+return
+`,
+			saved: 1,
+		},
+		{
+			// Any value but 0 is true, and not 1 is -2, which is still
+			// true, so the jumps have to stay as they are
+			name: "if on an int",
+			code: `function Main.f 0
+push argument 0
+if-goto IF_TRUE0
+goto IF_FALSE0
+label IF_TRUE0
+push constant 1
+return
+label IF_FALSE0
+push constant 2
+return
+`,
+			expected: `function Main.f 0
+push argument 0
+if-goto IF_TRUE0
+goto IF_FALSE0
+label IF_TRUE0
+push constant 1
+return
+label IF_FALSE0
+push constant 2
+return
+`,
+			saved: 0,
+		},
+		{
+			name: "if on not of an int",
+			code: `function Main.f 0
+push argument 0
+not
+if-goto IF_TRUE0
+goto IF_FALSE0
+label IF_TRUE0
+push constant 1
+return
+label IF_FALSE0
+push constant 2
+return
+`,
+			expected: `function Main.f 0
+push argument 0
+not
+if-goto IF_TRUE0
+goto IF_FALSE0
+label IF_TRUE0
+push constant 1
+return
+label IF_FALSE0
+push constant 2
+return
+`,
+			saved: 0,
+		},
+		{
+			name: "double not",
+			code: `function Main.f 0
+push constant 0
+not
+not
+return
+`,
+			expected: `function Main.f 0
+push constant 0
+return
+`,
+			saved: 2,
+		},
+		{
+			name: "constant conditions",
+			code: `function Main.f 0
+label WHILE_EXP0
+push constant 0
+not
+not
+if-goto WHILE_END0
+push constant 0
+if-goto WHILE_EXP0
+goto WHILE_EXP0
+label WHILE_END0
+push constant 0
+return
+`,
+			// With nothing jumping to the end of the loop, the return can
+			// never be reached
+			expected: `function Main.f 0
+label WHILE_EXP0
+goto WHILE_EXP0
+`,
+			saved: 9,
+		},
+		{
+			name: "comments and unreachable code",
+			code: `function Main.f 0
+// Main.jack:2  return 1;
+push constant 1
+return
+push constant 2
+// Main.jack:3  return 2;
+return
+function Main.g 0
+goto L
+label L
+label L2
+push constant 0
+return
+`,
+			expected: `function Main.f 0
+// Main.jack:2  return 1;
+push constant 1
+return
+// Main.jack:3  return 2;
+function Main.g 0
+push constant 0
+return
+`,
+			saved: 5,
+		},
+	}
+
+	for _, test := range tests {
+		optimised, saved := Optimise(test.code)
+
+		if diff := cmp.Diff(strings.Split(test.expected, "\n"), strings.Split(optimised, "\n")); diff != "" {
+			t.Errorf("%s: mismatch in optimised code (-expected +got):\n%s", test.name, diff)
+		}
+
+		if saved != test.saved {
+			t.Errorf("%s: expected %d instructions saved, got %d", test.name, test.saved, saved)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package codegenerator
+
+import (
+	"fmt"
+	"liggi-go-jack-compiler/token"
+	"strconv"
+	"strings"
+)
+
+// SourceMap links each line of generated VM code back to the innermost Jack
+// term, expression or statement, or for the function line itself the
+// subroutine, that produced it
+type SourceMap struct {
+	Mappings []Mapping `json:"mappings"`
+}
+
+// Mapping gives the Jack source of one VM line. VMLine is 0-based, while
+// lines and columns in the Jack source are 1-based, with the end exclusive.
+type Mapping struct {
+	VMLine      int    `json:"vmLine"`
+	File        string `json:"file"`
+	StartLine   int    `json:"startLine"`
+	StartColumn int    `json:"startColumn"`
+	EndLine     int    `json:"endLine"`
+	EndColumn   int    `json:"endColumn"`
+}
+
+// While generating, the code is built up as text, so the spans that lines
+// belong to are marked inline, then the markers are taken out in a final pass
+const (
+	beginMarker = "\x00begin "
+	endMarker   = "\x00end"
+)
+
+// beginSpan marks the start of the code for a node, if a source map is wanted
+func (c *CodeGenerator) beginSpan(node token.Node) string {
+	if !c.options.SourceMap {
+		return ""
+	}
+
+	c.spans = append(c.spans, node.Span())
+	return beginMarker + strconv.Itoa(len(c.spans)-1) + "\n"
+}
+
+// endSpan marks the end of the code for the node most recently begun
+func (c *CodeGenerator) endSpan() string {
+	if !c.options.SourceMap {
+		return ""
+	}
+
+	return endMarker + "\n"
+}
+
+// spanned marks code as coming from node, so that each line maps to the
+// innermost expression or term that produced it
+func (c *CodeGenerator) spanned(node token.Node, code string) string {
+	if code == "" {
+		return ""
+	}
+
+	return c.beginSpan(node) + code + c.endSpan()
+}
+
+// SourceMap returns the source map of the code last generated. It's only
+// filled in if the SourceMap option is set.
+func (c *CodeGenerator) SourceMap() SourceMap {
+	return c.sourceMap
+}
+
+// resolveSourceMap removes the span markers from generated code, recording
+// the innermost span around each remaining line
+func (c *CodeGenerator) resolveSourceMap(code string) (string, error) {
+	var output strings.Builder
+	var open []token.Span
+	vmLine := 0
+
+	for _, line := range strings.SplitAfter(code, "\n") {
+		switch {
+		case strings.HasPrefix(line, beginMarker):
+			i, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, beginMarker)))
+			if err != nil || i < 0 || i >= len(c.spans) {
+				return "", fmt.Errorf("invalid source map marker %q", line)
+			}
+			open = append(open, c.spans[i])
+
+		case strings.HasPrefix(line, endMarker):
+			if len(open) == 0 {
+				return "", fmt.Errorf("unbalanced source map marker")
+			}
+			open = open[:len(open)-1]
+
+		case line == "":
+
+		default:
+			if len(open) > 0 {
+				span := open[len(open)-1]
+				c.sourceMap.Mappings = append(c.sourceMap.Mappings, Mapping{
+					VMLine:      vmLine,
+					File:        span.Start.File,
+					StartLine:   span.Start.Line,
+					StartColumn: span.Start.Column,
+					EndLine:     span.End.Line,
+					EndColumn:   span.End.Column,
+				})
+			}
+
+			output.WriteString(line)
+			vmLine++
+		}
+	}
+
+	return output.String(), nil
+}
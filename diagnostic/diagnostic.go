@@ -0,0 +1,87 @@
+package diagnostic
+
+import (
+	"fmt"
+	"liggi-go-jack-compiler/token"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return fmt.Sprintf("severity(%d)", int(s))
+	}
+}
+
+// Diagnostic is a single problem found in a source file. Expected and Found
+// are only set when the problem is a mismatch between what the grammar
+// allowed and what was actually in the source.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	Span     token.Span
+	Expected string
+	Found    string
+}
+
+type List []Diagnostic
+
+func Errorf(span token.Span, format string, args ...interface{}) Diagnostic {
+	return Diagnostic{
+		Severity: Error,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     span,
+	}
+}
+
+func Warningf(span token.Span, format string, args ...interface{}) Diagnostic {
+	return Diagnostic{
+		Severity: Warning,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     span,
+	}
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s: %s", d.Span.Start, d.Severity, d.Message)
+}
+
+func (l List) Error() string {
+	messages := make([]string, len(l))
+	for i, d := range l {
+		messages[i] = d.Error()
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Err returns the list as an error if any of its diagnostics are errors, so
+// warnings alone don't stop compilation
+func (l List) Err() error {
+	if !l.HasErrors() {
+		return nil
+	}
+
+	return l
+}
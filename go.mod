@@ -2,4 +2,4 @@ module liggi-go-jack-compiler
 
 go 1.19
 
-require github.com/google/go-cmp v0.5.9 // indirect
+require github.com/google/go-cmp v0.5.9
@@ -0,0 +1,184 @@
+package jackos
+
+import (
+	"fmt"
+	"io"
+	vminterpreter "liggi-go-jack-compiler/vm-interpreter"
+	"strconv"
+)
+
+// print adds text to the captured output, interpreting the Hack character
+// set's newline and backspace keys
+func (o *OS) print(text string) {
+	for _, r := range text {
+		o.printRune(r)
+	}
+}
+
+func (o *OS) printRune(r rune) {
+	switch {
+	case r == newLine:
+		o.output = append(o.output, '\n')
+	case r == backSpace:
+		if len(o.output) > 0 && o.output[len(o.output)-1] != '\n' {
+			o.output = o.output[:len(o.output)-1]
+		}
+	case r >= 32 && r <= 126:
+		o.output = append(o.output, r)
+	default:
+		// The Jack OS prints a filled box for characters it has no font for
+		o.output = append(o.output, '█')
+	}
+}
+
+// moveCursor only checks its arguments, since the captured output is plain
+// text with no idea of a cursor
+func (o *OS) moveCursor(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if args[0] < 0 || args[0] > 22 || args[1] < 0 || args[1] > 63 {
+		return 0, fmt.Errorf("cursor position (%d, %d) is off the screen", args[0], args[1])
+	}
+
+	return 0, nil
+}
+
+func (o *OS) printChar(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	o.printRune(rune(args[0]))
+	return 0, nil
+}
+
+func (o *OS) printString(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	_, length, err := o.stringAt(interpreter, args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range chars(interpreter, args[0], length) {
+		o.printRune(rune(c))
+	}
+
+	return 0, nil
+}
+
+func (o *OS) printInt(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	o.print(strconv.Itoa(int(args[0])))
+	return 0, nil
+}
+
+func (o *OS) println(*vminterpreter.Interpreter, []int16) (int16, error) {
+	o.printRune(newLine)
+	return 0, nil
+}
+
+func (o *OS) backSpace(*vminterpreter.Interpreter, []int16) (int16, error) {
+	o.printRune(backSpace)
+	return 0, nil
+}
+
+// nextKey reads the next key from the scripted input, or returns 0 if
+// there's none left
+func (o *OS) nextKey() (int16, error) {
+	b, err := o.keyboard.ReadByte()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	switch b {
+	case '\n':
+		return newLine, nil
+	case '\b', 127:
+		return backSpace, nil
+	}
+
+	return int16(b), nil
+}
+
+// keyPressed alternates between pressing the next scripted key and releasing
+// it, so that programs waiting for a key to be let go see it happen. The key
+// is also mapped into RAM, where programs can read it directly.
+func (o *OS) keyPressed(interpreter *vminterpreter.Interpreter, _ []int16) (int16, error) {
+	if o.held != 0 {
+		o.held = 0
+	} else {
+		key, err := o.nextKey()
+		if err != nil {
+			return 0, err
+		}
+
+		o.held = key
+	}
+
+	interpreter.RAM[keyboard] = o.held
+	return o.held, nil
+}
+
+// readChar waits for the next key and echoes it to the output
+func (o *OS) readChar(interpreter *vminterpreter.Interpreter, _ []int16) (int16, error) {
+	key, err := o.nextKey()
+	if err != nil {
+		return 0, err
+	}
+
+	if key == 0 {
+		return 0, fmt.Errorf("program is waiting for keyboard input, but there's none left")
+	}
+
+	o.printRune(rune(key))
+	return key, nil
+}
+
+func (o *OS) readLine(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if _, err := o.printString(interpreter, args); err != nil {
+		return 0, err
+	}
+
+	var line []int16
+	for {
+		key, err := o.readChar(interpreter, nil)
+		if err != nil {
+			return 0, err
+		}
+
+		if key == newLine {
+			break
+		}
+
+		if key == backSpace {
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+			continue
+		}
+
+		line = append(line, key)
+	}
+
+	s, err := o.newString(interpreter, []int16{int16(len(line))})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range line {
+		if _, err := o.appendChar(interpreter, []int16{s, c}); err != nil {
+			return 0, err
+		}
+	}
+
+	return s, nil
+}
+
+func (o *OS) readInt(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	s, err := o.readLine(interpreter, args)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := o.intValue(interpreter, []int16{s})
+	if err != nil {
+		return 0, err
+	}
+
+	return value, o.deallocate(s)
+}
@@ -0,0 +1,221 @@
+package jackos
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	vminterpreter "liggi-go-jack-compiler/vm-interpreter"
+	"strings"
+)
+
+const (
+	heapBase   = 2048
+	heapEnd    = 16384
+	screenBase = 16384
+	keyboard   = 24576
+)
+
+// The Hack character set's special keys
+const (
+	newLine   = 128
+	backSpace = 129
+)
+
+// OS is a native implementation of the standard Jack OS classes, for running
+// compiled programs in the VM interpreter without the OS's own .vm files.
+// Anything printed with Output is captured as text rather than drawn, while
+// the screen is a real 512x256 bitmap at the usual place in RAM.
+type OS struct {
+	output   []rune
+	keyboard *bufio.Reader
+	held     int16
+	color    bool
+
+	free      []block
+	allocated map[int16]int16
+}
+
+type block struct {
+	address int16
+	size    int16
+}
+
+// New creates an OS whose keyboard types out the given input, one key at a
+// time, with newlines becoming the Enter key. keyboard may be nil.
+func New(keyboard io.Reader) *OS {
+	if keyboard == nil {
+		keyboard = strings.NewReader("")
+	}
+
+	return &OS{
+		keyboard:  bufio.NewReader(keyboard),
+		color:     true,
+		free:      []block{{address: heapBase, size: heapEnd - heapBase}},
+		allocated: map[int16]int16{},
+	}
+}
+
+// Output returns everything the program has printed so far
+func (o *OS) Output() string {
+	return string(o.output)
+}
+
+type native struct {
+	args int
+	fn   vminterpreter.Native
+}
+
+// Install registers every OS function with an interpreter. Functions the
+// program defines in its own VM code take precedence.
+func (o *OS) Install(interpreter *vminterpreter.Interpreter) {
+	natives := map[string]native{
+		"Math.init":     {0, o.noop},
+		"Math.abs":      {1, o.abs},
+		"Math.multiply": {2, o.multiply},
+		"Math.divide":   {2, o.divide},
+		"Math.min":      {2, o.min},
+		"Math.max":      {2, o.max},
+		"Math.sqrt":     {1, o.sqrt},
+
+		"Memory.init":    {0, o.noop},
+		"Memory.peek":    {1, o.peek},
+		"Memory.poke":    {2, o.poke},
+		"Memory.alloc":   {1, o.alloc},
+		"Memory.deAlloc": {1, o.deAlloc},
+
+		"Array.new":     {1, o.alloc},
+		"Array.dispose": {1, o.deAlloc},
+
+		"String.new":           {1, o.newString},
+		"String.dispose":       {1, o.deAlloc},
+		"String.length":        {1, o.length},
+		"String.charAt":        {2, o.charAt},
+		"String.setCharAt":     {3, o.setCharAt},
+		"String.appendChar":    {2, o.appendChar},
+		"String.eraseLastChar": {1, o.eraseLastChar},
+		"String.intValue":      {1, o.intValue},
+		"String.setInt":        {2, o.setInt},
+		"String.backSpace":     {0, constant(backSpace)},
+		"String.doubleQuote":   {0, constant('"')},
+		"String.newLine":       {0, constant(newLine)},
+
+		"Output.init":        {0, o.noop},
+		"Output.moveCursor":  {2, o.moveCursor},
+		"Output.printChar":   {1, o.printChar},
+		"Output.printString": {1, o.printString},
+		"Output.printInt":    {1, o.printInt},
+		"Output.println":     {0, o.println},
+		"Output.backSpace":   {0, o.backSpace},
+
+		"Screen.init":          {0, o.noop},
+		"Screen.clearScreen":   {0, o.clearScreen},
+		"Screen.setColor":      {1, o.setColor},
+		"Screen.drawPixel":     {2, o.drawPixel},
+		"Screen.drawLine":      {4, o.drawLine},
+		"Screen.drawRectangle": {4, o.drawRectangle},
+		"Screen.drawCircle":    {3, o.drawCircle},
+
+		"Keyboard.init":       {0, o.noop},
+		"Keyboard.keyPressed": {0, o.keyPressed},
+		"Keyboard.readChar":   {0, o.readChar},
+		"Keyboard.readLine":   {1, o.readLine},
+		"Keyboard.readInt":    {1, o.readInt},
+
+		"Sys.init":  {0, o.noop},
+		"Sys.halt":  {0, o.halt},
+		"Sys.error": {1, o.error},
+		"Sys.wait":  {1, o.wait},
+	}
+
+	for name, n := range natives {
+		interpreter.Register(name, withArgs(n))
+	}
+}
+
+// withArgs checks a native is called with the number of arguments it expects
+func withArgs(n native) vminterpreter.Native {
+	return func(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+		if len(args) != n.args {
+			return 0, fmt.Errorf("expects %d arguments, got %d", n.args, len(args))
+		}
+
+		return n.fn(interpreter, args)
+	}
+}
+
+func constant(value int16) vminterpreter.Native {
+	return func(*vminterpreter.Interpreter, []int16) (int16, error) {
+		return value, nil
+	}
+}
+
+func (o *OS) noop(*vminterpreter.Interpreter, []int16) (int16, error) {
+	return 0, nil
+}
+
+func (o *OS) halt(*vminterpreter.Interpreter, []int16) (int16, error) {
+	return 0, vminterpreter.ErrHalt
+}
+
+// error prints the error code as the Jack OS does, then stops the program
+func (o *OS) error(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	o.print(fmt.Sprintf("ERR%d", args[0]))
+	return 0, fmt.Errorf("program stopped with error code %d", args[0])
+}
+
+func (o *OS) wait(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return 0, fmt.Errorf("duration must be positive, got %d", args[0])
+	}
+
+	return 0, nil
+}
+
+func (o *OS) abs(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return -args[0], nil
+	}
+
+	return args[0], nil
+}
+
+func (o *OS) multiply(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	return args[0] * args[1], nil
+}
+
+func (o *OS) divide(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if args[1] == 0 {
+		return 0, fmt.Errorf("division by zero")
+	}
+
+	return args[0] / args[1], nil
+}
+
+func (o *OS) min(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if args[1] < args[0] {
+		return args[1], nil
+	}
+
+	return args[0], nil
+}
+
+func (o *OS) max(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if args[1] > args[0] {
+		return args[1], nil
+	}
+
+	return args[0], nil
+}
+
+func (o *OS) sqrt(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return 0, fmt.Errorf("cannot compute the square root of %d", args[0])
+	}
+
+	var root int16
+	for root < 181 && (root+1)*(root+1) <= args[0] {
+		root++
+	}
+
+	return root, nil
+}
@@ -0,0 +1,242 @@
+package jackos
+
+import (
+	"fmt"
+	vminterpreter "liggi-go-jack-compiler/vm-interpreter"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// load reads every .vm file in one of the test-cases programs
+func load(t *testing.T, program string) []vminterpreter.File {
+	t.Helper()
+
+	paths, err := filepath.Glob(filepath.Join("..", "test-cases", program, "*.vm"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no .vm files for %s: %v", program, err)
+	}
+
+	var files []vminterpreter.File
+	for _, path := range paths {
+		code, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(path), ".vm")
+		files = append(files, vminterpreter.File{Name: name, Code: string(code)})
+	}
+
+	return files
+}
+
+func run(t *testing.T, keyboard string, files ...vminterpreter.File) (*OS, *vminterpreter.Interpreter) {
+	t.Helper()
+
+	interpreter := vminterpreter.NewInterpreter(files...)
+	interpreter.MaxSteps = 1000000
+
+	jackOS := New(strings.NewReader(keyboard))
+	jackOS.Install(interpreter)
+
+	if err := interpreter.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return jackOS, interpreter
+}
+
+func TestOS_Seven(t *testing.T) {
+	jackOS, _ := run(t, "", load(t, "Seven")...)
+
+	if diff := cmp.Diff("7", jackOS.Output()); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestOS_Average(t *testing.T) {
+	// The 9 is typed and then rubbed out with a backspace
+	jackOS, _ := run(t, "3\n10\n29\b0\n30\n", load(t, "Average")...)
+
+	expected := "How many numbers? 3\n" +
+		"Enter a number: 10\n" +
+		"Enter a number: 20\n" +
+		"Enter a number: 30\n" +
+		"The average is 20"
+
+	if diff := cmp.Diff(expected, jackOS.Output()); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestOS_ConvertToBin(t *testing.T) {
+	interpreter := vminterpreter.NewInterpreter(load(t, "ConvertToBin")...)
+	interpreter.RAM[8000] = 0b0010000000000011
+
+	New(nil).Install(interpreter)
+	if err := interpreter.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []int16{1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0}
+	if diff := cmp.Diff(expected, interpreter.RAM[8001:8017]); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestOS_Strings(t *testing.T) {
+	code := `function Main.main 1
+push constant 6
+call String.new 1
+pop local 0
+push local 0
+push constant 45
+call String.appendChar 2
+push constant 52
+call String.appendChar 2
+push constant 50
+call String.appendChar 2
+call Output.printString 1
+pop temp 0
+push local 0
+call String.intValue 1
+call Output.printInt 1
+pop temp 0
+push local 0
+push constant 1234
+neg
+call String.setInt 2
+pop temp 0
+push local 0
+call String.eraseLastChar 1
+pop temp 0
+push local 0
+call Output.printString 1
+pop temp 0
+push local 0
+call String.length 1
+return`
+
+	jackOS, interpreter := run(t, "", vminterpreter.File{Name: "Main", Code: code})
+
+	if diff := cmp.Diff("-42-42-123", jackOS.Output()); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+
+	if interpreter.RAM[256] != 4 {
+		t.Errorf("expected a length of 4, got %d", interpreter.RAM[256])
+	}
+}
+
+func TestOS_Screen(t *testing.T) {
+	code := `function Main.main 0
+push constant 17
+push constant 2
+call Screen.drawPixel 2
+pop temp 0
+push constant 0
+push constant 10
+push constant 31
+push constant 10
+call Screen.drawLine 4
+pop temp 0
+push constant 0
+call Screen.setColor 1
+pop temp 0
+push constant 3
+push constant 10
+call Screen.drawPixel 2
+return`
+
+	_, interpreter := run(t, "", vminterpreter.File{Name: "Main", Code: code})
+
+	if interpreter.RAM[screenBase+2*32+1] != 2 {
+		t.Errorf("expected pixel (17, 2) to be set, got %016b", uint16(interpreter.RAM[screenBase+2*32+1]))
+	}
+
+	row := screenBase + 10*32
+	if interpreter.RAM[row] != ^int16(8) || interpreter.RAM[row+1] != -1 || interpreter.RAM[row+2] != 0 {
+		t.Errorf("expected a line across row 10 with pixel 3 cleared, got %016b %016b", uint16(interpreter.RAM[row]), uint16(interpreter.RAM[row+1]))
+	}
+}
+
+func TestOS_Memory(t *testing.T) {
+	jackOS := New(nil)
+
+	a, _ := jackOS.allocate(10)
+	b, _ := jackOS.allocate(20)
+	c, _ := jackOS.allocate(5)
+
+	if a != heapBase || b != heapBase+10 || c != heapBase+30 {
+		t.Errorf("expected blocks to be allocated in order, got %d %d %d", a, b, c)
+	}
+
+	// Freeing a and b leaves one block of 30 words, big enough for 25
+	for _, address := range []int16{a, b} {
+		if err := jackOS.deallocate(address); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if d, _ := jackOS.allocate(25); d != heapBase {
+		t.Errorf("expected freed blocks to be merged and reused, got %d", d)
+	}
+
+	if err := jackOS.deallocate(a + 1); err == nil {
+		t.Errorf("expected an error freeing memory that was never allocated")
+	}
+
+	if _, err := jackOS.allocate(heapEnd); err == nil {
+		t.Errorf("expected the heap to overflow")
+	}
+}
+
+func TestOS_Errors(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"push constant 1\npush constant 0\ncall Math.divide 2", "Main.vm:4: Math.divide: division by zero"},
+		{"push constant 0\ncall String.new 1\npush constant 65\ncall String.appendChar 2", "Main.vm:5: String.appendChar: String is full, at its maximum length of 0"},
+		{"push constant 600\npush constant 0\ncall Screen.drawPixel 2", "Main.vm:4: Screen.drawPixel: (600, 0) is off the screen"},
+		{"call Keyboard.readChar 0", "Main.vm:2: Keyboard.readChar: program is waiting for keyboard input, but there's none left"},
+		{"push constant 1\ncall Math.multiply 1", "Main.vm:3: Math.multiply: expects 2 arguments, got 1"},
+		{"push constant 3\ncall Sys.error 1", "Main.vm:3: Sys.error: program stopped with error code 3"},
+		// The program can overwrite a String's header, making its length
+		// point far past the block, or its maximum length negative
+		{corruptString(1, "32767") + "push constant 32000\ncall String.charAt 2",
+			"Main.vm:11: String.charAt: String 2048 is corrupt, with length 32767 and maximum length 3 in a block of 5 words"},
+		{corruptString(1, "32767") + "push constant 32000\npush constant 65\ncall String.setCharAt 3",
+			"Main.vm:12: String.setCharAt: String 2048 is corrupt, with length 32767 and maximum length 3 in a block of 5 words"},
+		{corruptString(0, "1\nneg") + "call Output.printString 1",
+			"Main.vm:11: Output.printString: String 2048 is corrupt, with length 0 and maximum length -1 in a block of 5 words"},
+	}
+
+	for _, test := range tests {
+		interpreter := vminterpreter.NewInterpreter(vminterpreter.File{Name: "Main", Code: "function Main.main 0\n" + test.code + "\nreturn"})
+		New(nil).Install(interpreter)
+
+		err := interpreter.Run()
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("expected error %q, got %v", test.expected, err)
+		}
+	}
+}
+
+// corruptString makes a String of maximum length 3, overwrites the header
+// word at offset with value, and leaves the String on the stack
+func corruptString(offset int, value string) string {
+	return fmt.Sprintf(`push constant 3
+call String.new 1
+pop temp 0
+push temp 0
+pop pointer 1
+push constant %s
+pop that %d
+push temp 0
+`, value, offset)
+}
@@ -0,0 +1,87 @@
+package jackos
+
+import (
+	"fmt"
+	vminterpreter "liggi-go-jack-compiler/vm-interpreter"
+	"sort"
+)
+
+func (o *OS) peek(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return 0, fmt.Errorf("address %d is outside of memory", args[0])
+	}
+
+	return interpreter.RAM[args[0]], nil
+}
+
+func (o *OS) poke(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return 0, fmt.Errorf("address %d is outside of memory", args[0])
+	}
+
+	interpreter.RAM[args[0]] = args[1]
+	return 0, nil
+}
+
+func (o *OS) alloc(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	return o.allocate(args[0])
+}
+
+func (o *OS) deAlloc(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	return 0, o.deallocate(args[0])
+}
+
+// allocate finds the first free block on the heap big enough for size words.
+// The heap's bookkeeping is kept outside of RAM, so the program can't corrupt
+// it.
+func (o *OS) allocate(size int16) (int16, error) {
+	if size <= 0 {
+		return 0, fmt.Errorf("allocated memory size must be positive, got %d", size)
+	}
+
+	for i, free := range o.free {
+		if free.size < size {
+			continue
+		}
+
+		if free.size == size {
+			o.free = append(o.free[:i], o.free[i+1:]...)
+		} else {
+			o.free[i] = block{address: free.address + size, size: free.size - size}
+		}
+
+		o.allocated[free.address] = size
+		return free.address, nil
+	}
+
+	return 0, fmt.Errorf("heap overflow allocating %d words", size)
+}
+
+// deallocate returns a block to the heap, merging it with any free blocks
+// either side of it
+func (o *OS) deallocate(address int16) error {
+	size, ok := o.allocated[address]
+	if !ok {
+		return fmt.Errorf("cannot free %d, which was never allocated", address)
+	}
+
+	delete(o.allocated, address)
+	o.free = append(o.free, block{address: address, size: size})
+
+	sort.Slice(o.free, func(i, j int) bool {
+		return o.free[i].address < o.free[j].address
+	})
+
+	merged := o.free[:1]
+	for _, free := range o.free[1:] {
+		last := &merged[len(merged)-1]
+		if last.address+last.size == free.address {
+			last.size += free.size
+		} else {
+			merged = append(merged, free)
+		}
+	}
+	o.free = merged
+
+	return nil
+}
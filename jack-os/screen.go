@@ -0,0 +1,151 @@
+package jackos
+
+import (
+	"fmt"
+	vminterpreter "liggi-go-jack-compiler/vm-interpreter"
+)
+
+const (
+	screenWidth  = 512
+	screenHeight = 256
+)
+
+func (o *OS) clearScreen(interpreter *vminterpreter.Interpreter, _ []int16) (int16, error) {
+	for i := screenBase; i < keyboard; i++ {
+		interpreter.RAM[i] = 0
+	}
+
+	return 0, nil
+}
+
+func (o *OS) setColor(_ *vminterpreter.Interpreter, args []int16) (int16, error) {
+	o.color = args[0] != 0
+	return 0, nil
+}
+
+// setPixel sets or clears a pixel in the current colour. Each row of the
+// screen is 32 words, with the leftmost pixel in each word's lowest bit.
+func (o *OS) setPixel(interpreter *vminterpreter.Interpreter, x, y int) {
+	if x < 0 || x >= screenWidth || y < 0 || y >= screenHeight {
+		return
+	}
+
+	address := screenBase + y*32 + x/16
+	mask := int16(1) << (x % 16)
+
+	if o.color {
+		interpreter.RAM[address] |= mask
+	} else {
+		interpreter.RAM[address] &^= mask
+	}
+}
+
+func checkPoint(x, y int16) error {
+	if x < 0 || x >= screenWidth || y < 0 || y >= screenHeight {
+		return fmt.Errorf("(%d, %d) is off the screen", x, y)
+	}
+
+	return nil
+}
+
+func (o *OS) drawPixel(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if err := checkPoint(args[0], args[1]); err != nil {
+		return 0, err
+	}
+
+	o.setPixel(interpreter, int(args[0]), int(args[1]))
+	return 0, nil
+}
+
+// drawLine draws a line between two points with Bresenham's algorithm
+func (o *OS) drawLine(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	for i := 0; i < 4; i += 2 {
+		if err := checkPoint(args[i], args[i+1]); err != nil {
+			return 0, err
+		}
+	}
+
+	x, y := int(args[0]), int(args[1])
+	x2, y2 := int(args[2]), int(args[3])
+
+	dx, dy := abs(x2-x), -abs(y2-y)
+	stepX, stepY := sign(x2-x), sign(y2-y)
+	err := dx + dy
+
+	for {
+		o.setPixel(interpreter, x, y)
+		if x == x2 && y == y2 {
+			return 0, nil
+		}
+
+		if 2*err >= dy {
+			err += dy
+			x += stepX
+		}
+		if 2*err <= dx {
+			err += dx
+			y += stepY
+		}
+	}
+}
+
+func (o *OS) drawRectangle(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	for i := 0; i < 4; i += 2 {
+		if err := checkPoint(args[i], args[i+1]); err != nil {
+			return 0, err
+		}
+	}
+
+	if args[0] > args[2] || args[1] > args[3] {
+		return 0, fmt.Errorf("rectangle corners must be top left then bottom right")
+	}
+
+	for y := int(args[1]); y <= int(args[3]); y++ {
+		for x := int(args[0]); x <= int(args[2]); x++ {
+			o.setPixel(interpreter, x, y)
+		}
+	}
+
+	return 0, nil
+}
+
+// drawCircle draws a filled circle, clipping any of it that's off the screen
+func (o *OS) drawCircle(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if err := checkPoint(args[0], args[1]); err != nil {
+		return 0, err
+	}
+
+	if args[2] < 0 || args[2] > 181 {
+		return 0, fmt.Errorf("radius must be between 0 and 181, got %d", args[2])
+	}
+
+	cx, cy, r := int(args[0]), int(args[1]), int(args[2])
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if dx*dx+dy*dy <= r*r {
+				o.setPixel(interpreter, cx+dx, cy+dy)
+			}
+		}
+	}
+
+	return 0, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+
+	return 0
+}
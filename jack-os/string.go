@@ -0,0 +1,170 @@
+package jackos
+
+import (
+	"fmt"
+	vminterpreter "liggi-go-jack-compiler/vm-interpreter"
+	"strconv"
+)
+
+// A String is a heap block of its maximum length, its current length, then
+// its characters
+
+func (o *OS) newString(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	if args[0] < 0 {
+		return 0, fmt.Errorf("maximum length must not be negative, got %d", args[0])
+	}
+
+	s, err := o.allocate(args[0] + 2)
+	if err != nil {
+		return 0, err
+	}
+
+	interpreter.RAM[s] = args[0]
+	interpreter.RAM[s+1] = 0
+	return s, nil
+}
+
+// stringAt checks that s points at memory allocated on the heap, and that its
+// header fits the block, so a bad String can't be used to read or write
+// anywhere else in RAM. The program can overwrite the header, so it's checked
+// every time. It returns the maximum and current lengths.
+func (o *OS) stringAt(interpreter *vminterpreter.Interpreter, s int16) (int, int, error) {
+	size, ok := o.allocated[s]
+	if !ok {
+		return 0, 0, fmt.Errorf("%d is not a String", s)
+	}
+
+	maxLength, length := int(interpreter.RAM[s]), int(interpreter.RAM[s+1])
+	if maxLength < 0 || maxLength > int(size)-2 || length < 0 || length > maxLength {
+		return 0, 0, fmt.Errorf("String %d is corrupt, with length %d and maximum length %d in a block of %d words", s, length, maxLength, size)
+	}
+
+	return maxLength, length, nil
+}
+
+func (o *OS) length(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	_, length, err := o.stringAt(interpreter, args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	return int16(length), nil
+}
+
+func (o *OS) charAt(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	s, j := int(args[0]), int(args[1])
+	_, length, err := o.stringAt(interpreter, args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	if j < 0 || j >= length {
+		return 0, fmt.Errorf("index %d out of range for a String of length %d", j, length)
+	}
+
+	return interpreter.RAM[s+2+j], nil
+}
+
+func (o *OS) setCharAt(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	s, j := int(args[0]), int(args[1])
+	_, length, err := o.stringAt(interpreter, args[0])
+	if err != nil {
+		return 0, err
+	}
+
+	if j < 0 || j >= length {
+		return 0, fmt.Errorf("index %d out of range for a String of length %d", j, length)
+	}
+
+	interpreter.RAM[s+2+j] = args[2]
+	return 0, nil
+}
+
+func (o *OS) appendChar(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	s := args[0]
+	maxLength, length, err := o.stringAt(interpreter, s)
+	if err != nil {
+		return 0, err
+	}
+
+	if length >= maxLength {
+		return 0, fmt.Errorf("String is full, at its maximum length of %d", maxLength)
+	}
+
+	interpreter.RAM[int(s)+2+length] = args[1]
+	interpreter.RAM[s+1] = int16(length + 1)
+	return s, nil
+}
+
+func (o *OS) eraseLastChar(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	s := args[0]
+	_, length, err := o.stringAt(interpreter, s)
+	if err != nil {
+		return 0, err
+	}
+
+	if length == 0 {
+		return 0, fmt.Errorf("String is empty")
+	}
+
+	interpreter.RAM[s+1] = int16(length - 1)
+	return 0, nil
+}
+
+// intValue reads the integer at the start of a String, stopping at the first
+// character that isn't a digit
+func (o *OS) intValue(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	s := args[0]
+	_, length, err := o.stringAt(interpreter, s)
+	if err != nil {
+		return 0, err
+	}
+
+	chars := chars(interpreter, s, length)
+
+	negative := len(chars) > 0 && chars[0] == '-'
+	if negative {
+		chars = chars[1:]
+	}
+
+	var value int16
+	for _, c := range chars {
+		if c < '0' || c > '9' {
+			break
+		}
+
+		value = value*10 + (c - '0')
+	}
+
+	if negative {
+		return -value, nil
+	}
+
+	return value, nil
+}
+
+func (o *OS) setInt(interpreter *vminterpreter.Interpreter, args []int16) (int16, error) {
+	s := args[0]
+	maxLength, _, err := o.stringAt(interpreter, s)
+	if err != nil {
+		return 0, err
+	}
+
+	digits := strconv.Itoa(int(args[1]))
+	if len(digits) > maxLength {
+		return 0, fmt.Errorf("%s doesn't fit in a String of maximum length %d", digits, maxLength)
+	}
+
+	for i, c := range digits {
+		interpreter.RAM[int(s)+2+i] = int16(c)
+	}
+	interpreter.RAM[s+1] = int16(len(digits))
+
+	return 0, nil
+}
+
+// chars returns the characters of a String, whose length must already have
+// been checked by stringAt
+func chars(interpreter *vminterpreter.Interpreter, s int16, length int) []int16 {
+	return interpreter.RAM[int(s)+2 : int(s)+2+length]
+}
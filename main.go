@@ -1,21 +1,66 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"liggi-go-jack-compiler/assembler"
 	codegenerator "liggi-go-jack-compiler/code-generator"
+	"liggi-go-jack-compiler/diagnostic"
+	jackos "liggi-go-jack-compiler/jack-os"
 	"liggi-go-jack-compiler/parser"
+	"liggi-go-jack-compiler/semantic"
+	"liggi-go-jack-compiler/serialiser"
+	"liggi-go-jack-compiler/token"
 	"liggi-go-jack-compiler/tokeniser"
+	"liggi-go-jack-compiler/vm"
+	vminterpreter "liggi-go-jack-compiler/vm-interpreter"
+	vmtranslator "liggi-go-jack-compiler/vm-translator"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
+var xmlMode = flag.Bool("xml", false, "write nand2tetris FooT.xml and Foo.xml files next to each .jack file instead of compiling")
+var asmMode = flag.Bool("asm", false, "also link every .vm file in each program directory into a single Hack assembly file, Dir/Dir.asm. The OS isn't generated, so the OS .vm files must be copied into the directory first")
+var hackMode = flag.Bool("hack", false, "also assemble each program into a ROM image, Dir/Dir.hack (implies -asm, so the OS .vm files must be copied into the directory first)")
+var runMode = flag.Bool("run", false, "run each program after compiling it, with keyboard input from stdin, and print its output")
+var annotate = flag.Bool("annotate", false, "comment the generated .vm files with the Jack statement each piece of code comes from")
+var sourceMap = flag.Bool("sourcemap", false, "write a JSON source map, Foo.vm.map, next to each .vm file, linking its lines to the Jack source, and with -asm, Dir/Dir.asm.map for the assembly")
+var optimise = flag.Bool("optimise", false, "run the peephole optimiser over the generated VM code")
+var foldConstants = flag.Bool("fold", false, "work out expressions made of constants at compile time")
+var strict = flag.Bool("strict", false, "treat type problems as errors rather than warnings")
+
+type sourceFile struct {
+	path   string
+	source string
+	tokens []token.Token
+	syntax []token.Node
+}
+
 func main() {
-	if len(os.Args) < 2 {
+	flag.Parse()
+
+	if flag.NArg() < 1 {
 		log.Fatal("No folder specified")
 	}
 
-	folderPath := os.Args[1]
+	if !compileFolder(flag.Arg(0)) {
+		os.Exit(1)
+	}
+}
+
+// compileFolder compiles every program in a folder, reporting false if any of
+// them failed. A program that can't be compiled or translated doesn't stop the
+// others.
+func compileFolder(folderPath string) bool {
+	// Each directory is a separate program, so its files are compiled
+	// together against a registry of just the classes in that directory
+	programs := map[string][]string{}
 
 	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -23,7 +68,8 @@ func main() {
 		}
 
 		if !info.IsDir() && strings.HasSuffix(path, ".jack") {
-			processFile(path)
+			dir := filepath.Dir(path)
+			programs[dir] = append(programs[dir], path)
 		}
 
 		return nil
@@ -32,42 +78,346 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	dirs := make([]string, 0, len(programs))
+	for dir := range programs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	failed := false
+
+	for _, dir := range dirs {
+		if !processProgram(programs[dir]) {
+			failed = true
+			continue
+		}
+
+		if (*asmMode || *hackMode) && !*xmlMode {
+			if err := translateProgram(dir); err != nil {
+				log.Printf("%s: %v", dir, err)
+				failed = true
+			}
+		}
+
+		if *runMode && !*xmlMode {
+			if err := runProgram(dir); err != nil {
+				log.Printf("%s: %v", dir, err)
+				failed = true
+			}
+		}
+	}
+
+	return !failed
 }
 
-func processFile(filePath string) {
-	file, err := os.Open(filePath)
+// processProgram compiles the .jack files of one program, reporting false if
+// any of them has errors, in which case none of its .vm files are written
+func processProgram(filePaths []string) bool {
+	failed := false
+
+	var files []sourceFile
+	for _, filePath := range filePaths {
+		file, err := parseFile(filePath)
+		if err != nil {
+			logError(err)
+			failed = true
+			continue
+		}
+
+		files = append(files, file)
+	}
+
+	if failed {
+		return false
+	}
+
+	if *xmlMode {
+		for _, file := range files {
+			basePath := strings.TrimSuffix(file.path, filepath.Ext(file.path))
+
+			writeFile(basePath+"T.xml", serialiser.TokensXML(file.tokens))
+			writeFile(basePath+".xml", serialiser.TreeXML(file.syntax))
+		}
+
+		return true
+	}
+
+	registry := semantic.NewRegistry()
+	for _, file := range files {
+		diagnostics := registry.Collect(file.syntax)
+		for _, d := range diagnostics {
+			log.Println(d)
+		}
+
+		failed = failed || diagnostics.HasErrors()
+	}
+
+	if failed {
+		return false
+	}
+
+	checker := semantic.NewChecker(registry)
+	checker.Strict = *strict
+
+	for _, file := range files {
+		diagnostics := checker.Check(file.syntax)
+		for _, d := range diagnostics {
+			log.Println(d)
+		}
+
+		failed = failed || diagnostics.HasErrors()
+	}
+
+	if failed {
+		return false
+	}
+
+	// Every file is generated before any is written, so a program that fails
+	// part way through leaves no .vm files behind
+	var outputs []output
+
+	for _, file := range files {
+		codeGenerator := codegenerator.NewCodeGenerator(file.syntax, codegenerator.Options{
+			Annotate:      *annotate,
+			Source:        file.source,
+			SourceMap:     *sourceMap,
+			Optimise:      *optimise,
+			FoldConstants: *foldConstants,
+		})
+		generated, err := codeGenerator.Generate()
+		if err != nil {
+			logError(err)
+			failed = true
+			continue
+		}
+
+		if *optimise {
+			log.Printf("%s: optimiser saved %d instructions", file.path, codeGenerator.InstructionsSaved())
+		}
+
+		vmPath := strings.TrimSuffix(file.path, filepath.Ext(file.path)) + ".vm"
+		outputs = append(outputs, output{vmPath, generated})
+
+		if *sourceMap {
+			encoded, err := json.MarshalIndent(codeGenerator.SourceMap(), "", "  ")
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			outputs = append(outputs, output{vmPath + ".map", string(encoded) + "\n"})
+		}
+	}
+
+	if failed {
+		return false
+	}
+
+	for _, output := range outputs {
+		writeFile(output.path, output.contents)
+	}
+
+	return true
+}
+
+// output is a file to be written once a whole program has compiled
+type output struct {
+	path     string
+	contents string
+}
+
+// logError logs each diagnostic in err on its own line, or err itself if it
+// isn't a list of diagnostics
+func logError(err error) {
+	var diagnostics diagnostic.List
+	if !errors.As(err, &diagnostics) {
+		log.Println(err)
+		return
+	}
+
+	for _, d := range diagnostics {
+		log.Println(d)
+	}
+}
+
+// translateProgram links every .vm file in a directory, including any OS
+// files copied there, into one .asm file named after the directory, then
+// assembles it if asked to
+func translateProgram(dir string) error {
+	translator := vmtranslator.NewVMTranslator(readVMFiles(dir)...)
+	asm, err := translator.Translate()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer file.Close()
 
-	tokeniser := tokeniser.NewTokeniser(file)
-	tokens, err := tokeniser.Tokenise()
+	// The directory may be given as ".", so its name comes from its full path
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	basePath := filepath.Join(dir, filepath.Base(absDir))
+	writeFile(basePath+".asm", asm)
+
+	if *sourceMap {
+		writeAsmSourceMap(dir, basePath+".asm.map", translator.SourceMap())
+	}
+
+	if !*hackMode {
+		return nil
+	}
+
+	hack, err := assembler.NewAssembler(asm, basePath+".asm").Assemble()
+	if err != nil {
+		return err
+	}
+
+	writeFile(basePath+".hack", hack)
+	return nil
+}
+
+// asmMapping is one line of an .asm.map. It has the VM command the line of
+// assembly came from and, if the VM file has a source map, the Jack source
+// that command came from.
+type asmMapping struct {
+	vmtranslator.Mapping
+	Jack *codegenerator.Mapping `json:"jack,omitempty"`
+}
+
+// writeAsmSourceMap joins the translator's source map with the source map of
+// each .vm file in dir, so each line of assembly can be traced back to Jack.
+// VM files without a source map, such as copies of the OS, are left at VM.
+func writeAsmSourceMap(dir, path string, sourceMap vmtranslator.SourceMap) {
+	jack := map[string]map[int]codegenerator.Mapping{}
+
+	vmMaps, err := filepath.Glob(filepath.Join(dir, "*.vm.map"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	parser := parser.NewParser(tokens)
-	syntax, err := parser.Parse()
+	for _, vmMap := range vmMaps {
+		encoded, err := os.ReadFile(vmMap)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var decoded codegenerator.SourceMap
+		if err := json.Unmarshal(encoded, &decoded); err != nil {
+			log.Fatalf("error reading source map %s: %v", vmMap, err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(vmMap), ".vm.map")
+		jack[name] = map[int]codegenerator.Mapping{}
+		for _, mapping := range decoded.Mappings {
+			jack[name][mapping.VMLine] = mapping
+		}
+	}
+
+	var mappings []asmMapping
+	for _, mapping := range sourceMap.Mappings {
+		joined := asmMapping{Mapping: mapping}
+		if source, ok := jack[mapping.File][mapping.VMLine]; ok {
+			joined.Jack = &source
+		}
+
+		mappings = append(mappings, joined)
+	}
+
+	encoded, err := json.MarshalIndent(struct {
+		Mappings []asmMapping `json:"mappings"`
+	}{mappings}, "", "  ")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	codeGenerator := codegenerator.NewCodeGenerator(syntax)
-	generated, err := codeGenerator.Generate()
+	writeFile(path, string(encoded)+"\n")
+}
+
+// runProgram runs every .vm file in a directory in the interpreter, using the
+// native OS for any OS functions the program doesn't define itself. Whatever
+// the program printed is shown even if it fails.
+func runProgram(dir string) error {
+	interpreter := vminterpreter.NewInterpreter(readVMFiles(dir)...)
+
+	jackOS := jackos.New(os.Stdin)
+	jackOS.Install(interpreter)
+
+	err := interpreter.Run()
+	fmt.Println(jackOS.Output())
+
+	return err
+}
+
+func readVMFiles(dir string) []vm.File {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.vm"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	vmFilePath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".vm"
-	vmFile, err := os.Create(vmFilePath)
+	var files []vm.File
+	for _, path := range paths {
+		code, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(path), ".vm")
+		files = append(files, vm.File{Name: name, Code: string(code)})
+	}
+
+	return files
+}
+
+// parseFile parses a .jack file. Unless the tokens are wanted for -xml, the
+// parser reads them straight from the tokeniser as it goes, so the file's
+// tokens are never all held at once.
+func parseFile(filePath string) (sourceFile, error) {
+	file := sourceFile{path: filePath}
+
+	if *xmlMode {
+		source, err := os.ReadFile(filePath)
+		if err != nil {
+			return file, err
+		}
+
+		file.tokens, err = tokeniser.NewTokeniser(bytes.NewReader(source), filePath).Tokenise()
+		if err != nil {
+			return file, err
+		}
+
+		file.syntax, err = parser.NewParser(file.tokens).Parse()
+		return file, err
+	}
+
+	// Annotations quote the source, so only then is all of it read in
+	if *annotate {
+		source, err := os.ReadFile(filePath)
+		if err != nil {
+			return file, err
+		}
+
+		file.source = string(source)
+	}
+
+	reader, err := os.Open(filePath)
+	if err != nil {
+		return file, err
+	}
+	defer reader.Close()
+
+	file.syntax, err = parser.NewStreamingParser(tokeniser.NewTokeniser(reader, filePath)).Parse()
+	return file, err
+}
+
+func writeFile(path, contents string) {
+	file, err := os.Create(path)
 	if err != nil {
-		log.Fatalf("error creating file %s: %v", vmFilePath, err)
+		log.Fatalf("error creating file %s: %v", path, err)
 	}
-	defer vmFile.Close()
+	defer file.Close()
 
-	_, err = vmFile.WriteString(generated)
+	_, err = file.WriteString(contents)
 	if err != nil {
-		log.Fatalf("error writing to file %s: %v", vmFilePath, err)
+		log.Fatalf("error writing to file %s: %v", path, err)
 	}
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTranslateProgram_CurrentDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "Program")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	code := "function Main.main 0\npush constant 0\nreturn\n"
+	if err := os.WriteFile(filepath.Join(dir, "Main.vm"), []byte(code), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	*hackMode, *sourceMap = true, true
+	defer func() { *hackMode, *sourceMap = false, false }()
+
+	// The output is named after the directory given as ".", so the test
+	// runs from inside it
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	if err := translateProgram("."); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"Program.asm", "Program.asm.map", "Program.hack"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("expected %s to be written: %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"..asm", "..asm.map", "..hack"} {
+		if _, err := os.Stat(name); err == nil {
+			t.Errorf("expected %s not to be written", name)
+		}
+	}
+}
+
+func TestProcessProgram_DuplicateClass(t *testing.T) {
+	dir := t.TempDir()
+
+	code := "class Main {\n\tfunction void main() {\n\t\treturn;\n\t}\n}\n"
+	var paths []string
+	for _, name := range []string{"Main.jack", "Other.jack"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(code), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		paths = append(paths, path)
+	}
+
+	if processProgram(paths) {
+		t.Fatal("expected a program declaring Main twice to fail")
+	}
+
+	if vmFiles, _ := filepath.Glob(filepath.Join(dir, "*.vm")); len(vmFiles) > 0 {
+		t.Errorf("expected no .vm files to be written, got %v", vmFiles)
+	}
+}
+
+// A syntax error in one program fails the run, but the others are still
+// compiled
+func TestCompileFolder_BrokenProgram(t *testing.T) {
+	root := t.TempDir()
+
+	programs := map[string]string{
+		"Broken": "class Main {\n\tfunction void main() {\n\t\tlet = 1;\n\t\treturn;\n\t}\n}\n",
+		"Good":   "class Main {\n\tfunction void main() {\n\t\treturn;\n\t}\n}\n",
+	}
+
+	for name, code := range programs {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, "Main.jack"), []byte(code), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if compileFolder(root) {
+		t.Error("expected the run to fail")
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "Good", "Main.vm")); err != nil {
+		t.Errorf("expected the good program to be compiled: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "Broken", "Main.vm")); err == nil {
+		t.Error("expected the broken program not to be compiled")
+	}
+}
@@ -1,9 +1,14 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"liggi-go-jack-compiler/diagnostic"
 	"liggi-go-jack-compiler/token"
+	"liggi-go-jack-compiler/tokeniser"
 	"reflect"
+	"strings"
 )
 
 type Token = token.Token
@@ -12,43 +17,108 @@ type Node = token.Node
 type Element = token.Element
 type PossibleTokens = token.PossibleTokens
 
+// TokenSource supplies the parser with tokens one at a time. Peek(n) looks n
+// tokens ahead without reading them. Both return io.EOF once the tokens run
+// out. A tokeniser.Tokeniser is a TokenSource, so a file can be parsed without
+// ever holding all of its tokens at once.
+type TokenSource interface {
+	Next() (Token, error)
+	Peek(n int) (Token, error)
+}
+
 type Parser struct {
-	tokens []Token
+	source      TokenSource
+	last        Token
+	diagnostics diagnostic.List
+
+	// err is the error the source failed with, if any, and failedAt how many
+	// diagnostics had been reported by then
+	err      error
+	failedAt int
 }
 
 func NewParser(tokens []Token) *Parser {
+	return NewStreamingParser(&sliceSource{tokens: tokens})
+}
+
+// NewStreamingParser parses tokens as they are read from source
+func NewStreamingParser(source TokenSource) *Parser {
 	return &Parser{
-		tokens: tokens,
+		source: source,
 	}
 }
 
 func (p *Parser) Scan() bool {
-	return len(p.tokens) > 0
+	_, ok := p.peek(0)
+	return ok
 }
 
 func (p *Parser) Next() Token {
-	token := p.tokens[0]
-	p.tokens = p.tokens[1:]
+	if !p.Scan() {
+		return p.eof()
+	}
+
+	// Having peeked at it, reading the token can't fail
+	token, _ := p.source.Next()
+	p.last = token
 
 	return token
 }
 
 func (p *Parser) Peek() Token {
-	if len(p.tokens) == 0 {
-		return Token{}
+	token, ok := p.peek(0)
+	if !ok {
+		return p.eof()
+	}
+
+	return token
+}
+
+// peek looks n tokens ahead. If the source fails with anything other than
+// io.EOF, the error is kept for Parse to return, and the tokens are treated
+// as having run out. So are they at the endOfFile token that a tokeniser
+// keeping trivia finishes with.
+func (p *Parser) peek(n int) (Token, bool) {
+	token, err := p.source.Peek(n)
+	if err != nil {
+		if err != io.EOF && p.err == nil {
+			p.err = err
+			p.failedAt = len(p.diagnostics)
+		}
+
+		return Token{}, false
 	}
 
-	return p.tokens[0]
+	return token, token.TokenType != "endOfFile"
+}
+
+// eof is the token returned once the input is exhausted. It has no type, but
+// sits just after the last real token so diagnostics still have a position.
+func (p *Parser) eof() Token {
+	return Token{Start: p.last.End, End: p.last.End}
 }
 
 func (p *Parser) Expect(expected TokenMatchable) (*Token, error) {
-	next := p.Next()
+	next := p.Peek()
 
 	if expected.Match(next) {
+		p.Next()
 		return &next, nil
 	}
 
-	return nil, fmt.Errorf("expected %s, encountered %s", expected, next)
+	if next.TokenType == "keyword" && expected.Match(token.Identifier(next.Value)) {
+		d := p.errorf(next, "keyword %s cannot be used as an identifier", next.Value)
+		d.Expected = "identifier"
+		d.Found = describeFound(next)
+
+		return nil, d
+	}
+
+	d := p.errorf(next, "expected %s, found %s", describe(expected), describeFound(next))
+	d.Expected = describe(expected)
+	d.Found = describeFound(next)
+
+	return nil, d
 }
 
 func (p *Parser) ExpectMaybe(expected TokenMatchable) (*Token, error) {
@@ -75,88 +145,107 @@ func (p *Parser) ExpectSequence(expected []TokenMatchable) ([]Token, error) {
 	return parsedTokens, nil
 }
 
+// Parse parses every token it was given. Syntax errors don't stop parsing:
+// each one is recorded, the parser skips ahead to the next statement or
+// declaration, and carries on. The tree returned is whatever could be parsed,
+// alongside every diagnostic found on the way, returned as a diagnostic.List.
 func (p *Parser) Parse() ([]Node, error) {
 	parsed := []Node{}
 
 	for p.Scan() {
 		token := p.Next()
 
+		var node Node
+		var err error
+
 		switch token.Value {
 		case "class":
-			parsedClass, err := p.parseClass(token)
-			if err != nil {
-				return nil, err
-			}
-			parsed = append(parsed, parsedClass)
-
+			node, err = p.parseClass(token)
 		case "let":
-			parsedLet, err := p.parseLet(token)
-			if err != nil {
-				return nil, err
-			}
-			parsed = append(parsed, parsedLet)
-
+			node, err = p.parseLet(token)
 		case "do":
-			parsedDo, err := p.parseDo(token)
-			if err != nil {
-				return nil, err
-			}
-			parsed = append(parsed, parsedDo)
-
+			node, err = p.parseDo(token)
 		case "if":
-			parsedIf, err := p.parseIf(token)
-			if err != nil {
-				return nil, err
-			}
-			parsed = append(parsed, parsedIf)
-
+			node, err = p.parseIf(token)
 		default:
-			return nil, fmt.Errorf("unexpected token: %s", token.Value)
+			err = p.errorf(token, "unexpected token: %s", token.Value)
 		}
+
+		if err != nil {
+			p.recoverFrom(err)
+			continue
+		}
+
+		parsed = append(parsed, node)
+	}
+
+	for _, node := range parsed {
+		if element, ok := node.(*Element); ok {
+			element.UpdateSpan()
+		}
+	}
+
+	// Syntax errors found after the source failed are only a symptom of it,
+	// but those found before are kept alongside it
+	if p.err != nil {
+		p.diagnostics = append(p.diagnostics[:p.failedAt], p.sourceDiagnostic())
+	}
+
+	return parsed, p.diagnostics.Err()
+}
+
+// sourceDiagnostic reports the error the source failed with, where the
+// tokeniser found it, or otherwise where the tokens ran out
+func (p *Parser) sourceDiagnostic() diagnostic.Diagnostic {
+	var sourceErr *tokeniser.Error
+	if errors.As(p.err, &sourceErr) {
+		span := token.Span{Start: sourceErr.Position, End: sourceErr.Position}
+		return diagnostic.Errorf(span, "%s", sourceErr.Message)
 	}
 
-	return parsed, nil
+	return p.errorf(p.eof(), "%s", p.err)
+}
+
+// Diagnostics returns everything reported so far
+func (p *Parser) Diagnostics() diagnostic.List {
+	return p.diagnostics
 }
 
 func (p *Parser) ParseUntil(terminator TokenMatchable) ([]Node, error) {
 	tokens := []Node{}
 
-	for !terminator.Match(p.Peek()) {
+	for p.Scan() && !terminator.Match(p.Peek()) {
 		next := p.Next()
 
-		switch next.Value {
-		case "field", "static":
-			parsed, err := p.parseClassVar(next)
-			if err != nil {
-				return nil, err
-			}
-			tokens = append(tokens, parsed)
+		var parsed Node
+		var err error
 
-		case "method", "function", "constructor":
-			parsed, err := p.parseSubroutine(next)
-			if err != nil {
-				return nil, err
-			}
-			tokens = append(tokens, parsed)
+		switch {
+		case next.TokenType != "keyword":
+			err = p.errorf(next, "unexpected token: %s", next.Value)
 
-		case "var":
-			parsed, err := p.parseVar(next)
-			if err != nil {
-				return nil, err
-			}
-			tokens = append(tokens, parsed)
+		case next.Value == "field" || next.Value == "static":
+			parsed, err = p.parseClassVar(next)
+
+		case next.Value == "method" || next.Value == "function" || next.Value == "constructor":
+			parsed, err = p.parseSubroutine(next)
+
+		case next.Value == "var":
+			parsed, err = p.parseVar(next)
+
+		case token.AnyStatement().Match(next):
+			parsed, err = p.parseStatement(next)
 
 		default:
-			if token.AnyStatement().Match(next) {
-				parsed, err := p.parseStatement(next)
-				if err != nil {
-					return nil, err
-				}
-				tokens = append(tokens, parsed)
-			} else {
-				return nil, fmt.Errorf("unexpected token: %s", next.Value)
-			}
+			err = p.errorf(next, "unexpected token: %s", next.Value)
+		}
+
+		if err != nil {
+			p.recoverFrom(err)
+			continue
 		}
+
+		tokens = append(tokens, parsed)
 	}
 
 	if len(tokens) == 0 {
@@ -166,12 +255,123 @@ func (p *Parser) ParseUntil(terminator TokenMatchable) ([]Node, error) {
 	return tokens, nil
 }
 
+// errorf builds a diagnostic pointing at the given token
+func (p *Parser) errorf(at Token, format string, args ...interface{}) diagnostic.Diagnostic {
+	return diagnostic.Errorf(at.Span(), format, args...)
+}
+
+// recoverFrom records err and moves the parser on to somewhere it can resume.
+// A keyword that was used as a name is skipped first, or synchronise would
+// stop at it and it would be parsed again as the start of something else.
+func (p *Parser) recoverFrom(err error) {
+	p.report(err)
+
+	var d diagnostic.Diagnostic
+	if errors.As(err, &d) && d.Expected == "identifier" && p.Peek().TokenType == "keyword" && d.Span == p.Peek().Span() {
+		p.Next()
+	}
+
+	p.synchronise()
+}
+
+func (p *Parser) report(err error) {
+	var d diagnostic.Diagnostic
+	if !errors.As(err, &d) {
+		d = p.errorf(p.Peek(), "%s", err)
+	}
+
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+// synchronise skips tokens until it finds a point parsing can sensibly
+// resume from: just past a `;`, or just before a `}` or a keyword that starts
+// a statement or declaration. Any block opened along the way is skipped whole,
+// so a broken `if` or `while` header doesn't leak its body into the block
+// around it.
+func (p *Parser) synchronise() {
+	depth := 0
+
+	for p.Scan() {
+		next := p.Peek()
+
+		switch {
+		case token.Symbol('{').Match(next):
+			depth++
+		case token.Symbol('}').Match(next):
+			if depth == 0 {
+				return
+			}
+			depth--
+		case depth == 0 && token.Symbol(';').Match(next):
+			p.Next()
+			return
+		case depth == 0 && synchronisingKeywords().Match(next):
+			return
+		}
+
+		p.Next()
+	}
+}
+
+// expectClosing expects a closing `}`. A missing brace is reported, but
+// doesn't throw away the block it would have closed.
+func (p *Parser) expectClosing() *Token {
+	closing, err := p.Expect(token.Symbol('}'))
+	if err != nil {
+		p.report(err)
+		return nil
+	}
+
+	return closing
+}
+
+func synchronisingKeywords() PossibleTokens {
+	return token.OneOf(
+		token.AnyStatement(),
+		token.Keyword("var"),
+		token.Keyword("field"),
+		token.Keyword("static"),
+		token.Keyword("function"),
+		token.Keyword("method"),
+		token.Keyword("constructor"),
+		token.Keyword("class"),
+	)
+}
+
+func describe(expected TokenMatchable) string {
+	switch expected := expected.(type) {
+	case Token:
+		if expected.Value == "" {
+			return expected.TokenType
+		}
+
+		return fmt.Sprintf("'%s'", expected.Value)
+	case PossibleTokens:
+		descriptions := make([]string, len(expected.Tokens))
+		for i, t := range expected.Tokens {
+			descriptions[i] = describe(t)
+		}
+
+		return strings.Join(descriptions, " or ")
+	}
+
+	return fmt.Sprint(expected)
+}
+
+func describeFound(found Token) string {
+	if found.TokenType == "" {
+		return "end of file"
+	}
+
+	return fmt.Sprintf("%s '%s'", found.TokenType, found.Value)
+}
+
 func (p *Parser) ParseRepeatedSequenceUntil(sequence []TokenMatchable, terminator Token) ([]Node, error) {
 	var parsedTokens []Node
 	seqIndex := 0
 
 	// If the very next token is the terminator, there's nothing to parse
-	if p.Peek() == terminator {
+	if terminator.Match(p.Peek()) {
 		return nil, nil
 	}
 
@@ -192,7 +392,7 @@ func (p *Parser) ParseRepeatedSequenceUntil(sequence []TokenMatchable, terminato
 
 		// At the end, check if the next token is the terminator
 		// and break if it is
-		if p.Peek() == terminator {
+		if terminator.Match(p.Peek()) {
 			break
 		}
 	}
@@ -214,10 +414,7 @@ func (p *Parser) parseClass(initial Token) (Node, error) {
 		return &Element{}, err
 	}
 
-	closingBracket, err := p.Expect(token.Symbol('}'))
-	if err != nil {
-		return &Element{}, err
-	}
+	closingBracket := p.expectClosing()
 
 	return &Element{
 		Tag: "class",
@@ -402,7 +599,7 @@ func (p *Parser) parseTerm() (Node, error) {
 		}, nil
 	}
 
-	return &Element{}, fmt.Errorf("unexpected token %s", next)
+	return &Element{}, p.errorf(next, "expected a term, found %s", describeFound(next))
 }
 
 func (p *Parser) parseExpression() (Node, error) {
@@ -411,30 +608,26 @@ func (p *Parser) parseExpression() (Node, error) {
 		return &Element{}, err
 	}
 
-	if !token.AnyOperation().Match(p.Peek()) {
-		return &token.Element{
-			Tag:      "expression",
-			Children: []Node{term},
-		}, nil
-	}
+	children := []Node{term}
 
-	operation, err := p.Expect(token.AnyOperation())
-	if err != nil {
-		return &Element{}, err
-	}
+	// An expression is a term followed by any number of `op term` pairs
+	for token.AnyOperation().Match(p.Peek()) {
+		operation, err := p.Expect(token.AnyOperation())
+		if err != nil {
+			return &Element{}, err
+		}
 
-	secondTerm, err := p.parseTerm()
-	if err != nil {
-		return &Element{}, err
+		nextTerm, err := p.parseTerm()
+		if err != nil {
+			return &Element{}, err
+		}
+
+		children = append(children, operation, nextTerm)
 	}
 
 	return &Element{
-		Tag: "expression",
-		Children: []Node{
-			term,
-			operation,
-			secondTerm,
-		},
+		Tag:      "expression",
+		Children: children,
 	}, nil
 }
 
@@ -464,10 +657,7 @@ func (p *Parser) parseWhile(initial Token) (Node, error) {
 		return &Element{}, err
 	}
 
-	closingBracket, err := p.Expect(token.Symbol('}'))
-	if err != nil {
-		return &Element{}, err
-	}
+	closingBracket := p.expectClosing()
 
 	return &Element{
 		Tag: "whileStatement",
@@ -549,19 +739,20 @@ func (p *Parser) parseStatement(initial Token) (Node, error) {
 	case "return":
 		return p.parseReturn(initial)
 	default:
-		return nil, fmt.Errorf("unexpected token: %s", initial.Value)
+		return nil, p.errorf(initial, "unexpected token: %s", initial.Value)
 	}
 }
 
 func (p *Parser) parseStatementsUntil(terminator Token) ([]Node, error) {
 	var statements []Node
 
-	for p.Peek() != terminator {
+	for p.Scan() && !terminator.Match(p.Peek()) {
 		token := p.Next()
 
 		statement, err := p.parseStatement(token)
 		if err != nil {
-			return nil, err
+			p.recoverFrom(err)
+			continue
 		}
 
 		statements = append(statements, statement)
@@ -620,7 +811,7 @@ func (p *Parser) parseDo(initial Token) (Node, error) {
 func (p *Parser) parseReturn(initial Token) (Node, error) {
 	next := p.Peek()
 
-	if next == token.Symbol(';') {
+	if token.Symbol(';').Match(next) {
 		endOfLine, err := p.Expect(token.Symbol(';'))
 		if err != nil {
 			return &Element{}, err
@@ -680,10 +871,7 @@ func (p *Parser) parseIf(initial Token) (Node, error) {
 		return &Element{}, err
 	}
 
-	closingBracket, err := p.Expect(token.Symbol('}'))
-	if err != nil {
-		return &Element{}, err
-	}
+	closingBracket := p.expectClosing()
 
 	ifNodes := combineNodeSlices(
 		[]Node{&initial, openBracket, expression, &openFirstStatements[0], &openFirstStatements[1]},
@@ -714,10 +902,7 @@ func (p *Parser) parseIf(initial Token) (Node, error) {
 			return &Element{}, err
 		}
 
-		closingBracket, err := p.Expect(token.Symbol('}'))
-		if err != nil {
-			return &Element{}, err
-		}
+		closingBracket := p.expectClosing()
 
 		ifNodes = combineNodeSlices(
 			ifNodes,
@@ -840,12 +1025,7 @@ func (p *Parser) parseSubroutine(initial Token) (Node, error) {
 		return &Element{}, err
 	}
 
-	closing, err := p.ExpectSequence([]TokenMatchable{
-		token.Symbol('}'),
-	})
-	if err != nil {
-		return &Element{}, err
-	}
+	closing := p.expectClosing()
 
 	return &Element{
 		Tag: "subroutineDec",
@@ -866,7 +1046,8 @@ func (p *Parser) parseSubroutine(initial Token) (Node, error) {
 							Tag:      "statements",
 							Children: statements,
 						},
-						&closing[0]},
+						closing,
+					},
 				),
 			},
 		},
@@ -903,3 +1084,27 @@ func combineNodeSlices(slices ...[]Node) []Node {
 
 	return result
 }
+
+// sliceSource is a TokenSource over tokens that have already been read
+type sliceSource struct {
+	tokens []Token
+}
+
+func (s *sliceSource) Next() (Token, error) {
+	if len(s.tokens) == 0 {
+		return Token{}, io.EOF
+	}
+
+	token := s.tokens[0]
+	s.tokens = s.tokens[1:]
+
+	return token, nil
+}
+
+func (s *sliceSource) Peek(n int) (Token, error) {
+	if n >= len(s.tokens) {
+		return Token{}, io.EOF
+	}
+
+	return s.tokens[n], nil
+}
@@ -1,11 +1,17 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"liggi-go-jack-compiler/diagnostic"
 	"liggi-go-jack-compiler/token"
+	"liggi-go-jack-compiler/tokeniser"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func element(tag string, elements ...Node) *token.Element {
@@ -397,6 +403,273 @@ func TestParser_IfStatement(t *testing.T) {
 	}
 }
 
+func TestParser_OperatorChain(t *testing.T) {
+	tokens := []Token{
+		token.Keyword("let"),
+		token.Identifier("x"),
+		token.Symbol('='),
+		token.Identifier("a"),
+		token.Symbol('*'),
+		token.IntegerConstant(2),
+		token.Symbol('+'),
+		token.Identifier("b"),
+		token.Symbol('-'),
+		token.IntegerConstant(1),
+		token.Symbol(';'),
+	}
+	parser := NewParser(tokens)
+
+	expected := []Node{
+		letStatement(
+			keyword("let"),
+			identifier("x"),
+			symbol('='),
+			expression(
+				term(
+					identifier("a"),
+				),
+				symbol('*'),
+				term(
+					integerConstant(2),
+				),
+				symbol('+'),
+				term(
+					identifier("b"),
+				),
+				symbol('-'),
+				term(
+					integerConstant(1),
+				),
+			),
+			symbol(';'),
+		),
+	}
+	parsed, err := parser.Parse()
+	if err != nil {
+		t.Errorf("Error %v", err)
+	}
+
+	diff := cmp.Diff(parsed, expected)
+	if diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestParser_Spans(t *testing.T) {
+	input := "class Main {\n  function void main() {\n    let x = 1 + 2;\n    return;\n  }\n}\n"
+	tokens, err := tokeniser.NewTokeniser(strings.NewReader(input), "Main.jack").Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected tokeniser error: %v", err)
+	}
+
+	parsed, err := NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("unexpected parser error: %v", err)
+	}
+
+	pos := func(line, column, offset int) token.Position {
+		return token.Position{File: "Main.jack", Line: line, Column: column, Offset: offset}
+	}
+
+	class := parsed[0].(*Element)
+	if diff := cmp.Diff(token.Span{Start: pos(1, 1, 0), End: pos(6, 2, 74)}, class.Span()); diff != "" {
+		t.Errorf("class span: %v", diff)
+	}
+
+	let := class.FindElement("letStatement")
+	if diff := cmp.Diff(token.Span{Start: pos(3, 5, 42), End: pos(3, 19, 56)}, let.Span()); diff != "" {
+		t.Errorf("let span: %v", diff)
+	}
+
+	expression := let.FindChildElement("expression")
+	if diff := cmp.Diff(token.Span{Start: pos(3, 13, 50), End: pos(3, 18, 55)}, expression.Span()); diff != "" {
+		t.Errorf("expression span: %v", diff)
+	}
+}
+
+func TestParser_Streaming(t *testing.T) {
+	source, err := os.ReadFile("../test-cases/Square/SquareGame.jack")
+	if err != nil {
+		t.Fatalf("failed to read test case: %v", err)
+	}
+
+	tokens, err := tokeniser.NewTokeniser(strings.NewReader(string(source))).Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected tokeniser error: %v", err)
+	}
+
+	expected, err := NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("unexpected parser error: %v", err)
+	}
+
+	parsed, err := NewStreamingParser(tokeniser.NewTokeniser(strings.NewReader(string(source)))).Parse()
+	if err != nil {
+		t.Fatalf("unexpected parser error: %v", err)
+	}
+
+	if diff := cmp.Diff(expected, parsed); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestParser_KeepsTrivia(t *testing.T) {
+	input := "class Main {\n  // Does nothing\n  function void main() { return; }\n}\n"
+
+	tokens, err := tokeniser.NewTokeniser(strings.NewReader(input)).Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected tokeniser error: %v", err)
+	}
+
+	expected, err := NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("unexpected parser error: %v", err)
+	}
+
+	parsed, err := NewStreamingParser(tokeniser.NewTokeniser(strings.NewReader(input)).KeepTrivia()).Parse()
+	if err != nil {
+		t.Fatalf("unexpected parser error: %v", err)
+	}
+
+	if diff := cmp.Diff(expected, parsed, cmpopts.IgnoreFields(Token{}, "Raw", "LeadingTrivia", "TrailingTrivia")); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+
+	function := parsed[0].(*Element).FindElement("subroutineDec")
+	first, _ := function.ChildAsToken(0)
+	if len(first.LeadingTrivia) != 3 || first.LeadingTrivia[1].Text != "// Does nothing" {
+		t.Errorf("expected the comment to lead the function, got %v", first.LeadingTrivia)
+	}
+}
+
+// A syntax error found before the tokeniser fails is still reported, along
+// with the tokeniser's error, but none of the errors that only follow from it
+func TestParser_StreamingTokeniserError(t *testing.T) {
+	input := "class Main {\n  function void main() {\n    let x 1;\n    let s = \"unclosed;\n  }\n}\n"
+
+	_, err := NewStreamingParser(tokeniser.NewTokeniser(strings.NewReader(input), "Main.jack")).Parse()
+
+	var diagnostics diagnostic.List
+	if !errors.As(err, &diagnostics) {
+		t.Fatalf("expected a diagnostic.List, got %v", err)
+	}
+
+	var messages []string
+	for _, d := range diagnostics {
+		messages = append(messages, d.Error())
+	}
+
+	expected := []string{
+		"Main.jack:3:11: error: expected '=', found integerConstant '1'",
+		"Main.jack:4:13: error: newline in string literal",
+	}
+
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("mismatch in diagnostics (-expected +got):\n%s", diff)
+	}
+}
+
+func TestParser_KeywordAsIdentifier(t *testing.T) {
+	tokens := []Token{
+		token.Keyword("let"),
+		token.Keyword("static"),
+		token.Symbol('='),
+		token.IntegerConstant(1),
+		token.Symbol(';'),
+	}
+
+	_, err := NewParser(tokens).Parse()
+	if err == nil || !strings.Contains(err.Error(), "keyword static cannot be used as an identifier") {
+		t.Errorf("expected keyword shadowing error, got %v", err)
+	}
+}
+
+// A keyword used as a name shouldn't then be parsed as the start of a
+// declaration or statement, which would report a second, cascaded error
+func TestParser_RecoversPastKeywordAsIdentifier(t *testing.T) {
+	input := `class Main {
+  function void main() {
+    var int static;
+    return;
+  }
+}`
+	tokens, err := tokeniser.NewTokeniser(strings.NewReader(input), "Main.jack").Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected tokeniser error: %v", err)
+	}
+
+	_, err = NewParser(tokens).Parse()
+
+	var diagnostics diagnostic.List
+	if !errors.As(err, &diagnostics) {
+		t.Fatalf("expected a diagnostic list, got %v", err)
+	}
+
+	var messages []string
+	for _, d := range diagnostics {
+		messages = append(messages, d.Error())
+	}
+
+	expected := []string{"Main.jack:3:13: error: keyword static cannot be used as an identifier"}
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestParser_RecoversFromErrors(t *testing.T) {
+	input := `class Main {
+  function void main() {
+    var int x;
+    let x = ;
+    let y 5;
+    do Output.printInt(x);
+    if (x +) { let x = 1; }
+    return;
+  }
+}`
+	tokens, err := tokeniser.NewTokeniser(strings.NewReader(input), "Main.jack").Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected tokeniser error: %v", err)
+	}
+
+	parsed, err := NewParser(tokens).Parse()
+
+	var diagnostics diagnostic.List
+	if !errors.As(err, &diagnostics) {
+		t.Fatalf("expected a diagnostic list, got %v", err)
+	}
+
+	expected := []string{
+		"Main.jack:4:13: error: expected a term, found symbol ';'",
+		"Main.jack:5:11: error: expected '=', found integerConstant '5'",
+		"Main.jack:7:12: error: expected a term, found symbol ')'",
+	}
+
+	var messages []string
+	for _, d := range diagnostics {
+		messages = append(messages, d.Error())
+	}
+
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+
+	if diagnostics[1].Expected != "'='" || diagnostics[1].Found != "integerConstant '5'" {
+		t.Errorf("unexpected expected/found: %q, %q", diagnostics[1].Expected, diagnostics[1].Found)
+	}
+
+	// The statements either side of the broken ones should still be parsed
+	statements := parsed[0].(*Element).FindElement("statements")
+	var tags []string
+	for _, statement := range statements.AllChildElements() {
+		tags = append(tags, statement.Tag)
+	}
+
+	if diff := cmp.Diff([]string{"doStatement", "returnStatement"}, tags); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
 func TestParser_ClassDeclaration(t *testing.T) {
 	tokens := []Token{
 		token.Keyword("class"),
@@ -0,0 +1,473 @@
+package semantic
+
+import (
+	"liggi-go-jack-compiler/diagnostic"
+	"liggi-go-jack-compiler/token"
+	"unicode"
+)
+
+// Checker walks parsed classes looking for problems that the grammar alone
+// can't catch, such as uses of names that were never declared, or values of
+// the wrong type
+type Checker struct {
+	// Strict makes type problems errors rather than warnings
+	Strict bool
+
+	registry    *Registry
+	diagnostics diagnostic.List
+
+	class      *Class
+	subroutine *Subroutine
+	scope      map[string]Variable
+}
+
+func NewChecker(registry *Registry) *Checker {
+	if registry == nil {
+		registry = NewRegistry()
+	}
+
+	return &Checker{registry: registry}
+}
+
+// Check checks every class in a parsed file, returning everything it found
+func (c *Checker) Check(nodes []token.Node) diagnostic.List {
+	c.diagnostics = nil
+
+	for _, node := range nodes {
+		element, ok := node.(*token.Element)
+		if !ok || element.Tag != "class" {
+			continue
+		}
+
+		c.checkClass(element)
+	}
+
+	return c.diagnostics
+}
+
+func (c *Checker) errorf(span token.Span, format string, args ...interface{}) {
+	c.diagnostics = append(c.diagnostics, diagnostic.Errorf(span, format, args...))
+}
+
+func (c *Checker) checkClass(class *token.Element) {
+	nameToken := class.FindChildToken("identifier")
+	if nameToken == nil {
+		return
+	}
+
+	c.class = c.registry.Class(nameToken.Value)
+	if c.class == nil || c.class.Builtin {
+		// The class hasn't been collected, so collect it on its own
+		c.class, _ = collectClass(class, nameToken.Value)
+	}
+
+	for _, dec := range class.AllChildElementsByTag("subroutineDec") {
+		c.checkSubroutine(dec)
+	}
+}
+
+func (c *Checker) checkSubroutine(dec *token.Element) {
+	c.scope = map[string]Variable{}
+	c.subroutine = collectSubroutine(dec, c.class.Name)
+
+	for _, variable := range c.class.Variables {
+		c.scope[variable.Name] = variable
+	}
+
+	list := dec.FindChildElement("parameterList")
+	if list != nil {
+		for _, parameter := range collectParameters(list) {
+			c.scope[parameter.Name] = Variable{Name: parameter.Name, Type: parameter.Type, Kind: "argument"}
+		}
+	}
+
+	body := dec.FindChildElement("subroutineBody")
+	if body == nil {
+		return
+	}
+
+	for _, varDec := range body.AllChildElementsByTag("varDec") {
+		for _, variable := range collectVariables(varDec) {
+			variable.Kind = "local"
+			c.scope[variable.Name] = variable
+		}
+	}
+
+	c.checkStatements(body.FindChildElement("statements"))
+}
+
+func (c *Checker) checkStatements(statements *token.Element) {
+	if statements == nil {
+		return
+	}
+
+	for _, statement := range statements.AllChildElements() {
+		c.checkStatement(statement)
+	}
+}
+
+func (c *Checker) checkStatement(statement *token.Element) {
+	switch statement.Tag {
+	case "letStatement":
+		c.checkLet(statement)
+
+	case "ifStatement", "whileStatement":
+		keyword, err := statement.ChildAsToken(0)
+		if err != nil {
+			return
+		}
+
+		condition := statement.FindChildElement("expression")
+		c.expectType(condition, c.checkExpression(condition), "boolean", keyword.Value+" condition")
+
+		for _, statements := range statement.AllChildElementsByTag("statements") {
+			c.checkStatements(statements)
+		}
+
+	case "doStatement":
+		c.checkCall(statement)
+
+	case "returnStatement":
+		c.checkReturn(statement)
+	}
+}
+
+func (c *Checker) checkLet(statement *token.Element) {
+	target := statement.FindChildToken("identifier")
+	if target == nil {
+		return
+	}
+
+	targetType := c.checkVariable(target)
+
+	expressions := statement.AllChildElementsByTag("expression")
+	if len(expressions) == 2 {
+		c.checkIndex(target, targetType, expressions[0])
+
+		// Array elements are untyped, so anything can be stored in one
+		targetType = ""
+	}
+
+	if len(expressions) == 0 {
+		return
+	}
+
+	value := expressions[len(expressions)-1]
+	valueType := c.checkExpression(value)
+
+	if !assignable(targetType, valueType) {
+		c.typeErrorf(value.Span(), "cannot assign %s to %s of type %s", valueType, target.Value, targetType)
+	}
+}
+
+func (c *Checker) checkReturn(statement *token.Element) {
+	expression := statement.FindChildElement("expression")
+	returnType := c.subroutine.ReturnType
+
+	if c.subroutine.Kind == "constructor" {
+		returnType = c.class.Name
+	}
+
+	switch {
+	case expression == nil && returnType != "void":
+		c.typeErrorf(statement.Span(), "%s must return a value of type %s", c.subroutine.Name, returnType)
+
+	case expression == nil:
+
+	case returnType == "void":
+		c.checkExpression(expression)
+		c.typeErrorf(expression.Span(), "void %s cannot return a value", c.subroutine.Name)
+
+	default:
+		valueType := c.checkExpression(expression)
+		if !assignable(returnType, valueType) {
+			c.typeErrorf(expression.Span(), "cannot return %s from %s, which returns %s", valueType, c.subroutine.Name, returnType)
+		}
+	}
+}
+
+// checkExpression checks an expression, returning its type, or "" if the type
+// can't be known
+func (c *Checker) checkExpression(expression *token.Element) string {
+	if expression == nil {
+		return ""
+	}
+
+	var result string
+	var pendingOp *token.Token
+
+	for _, child := range expression.Children {
+		switch child := child.(type) {
+		case *token.Token:
+			pendingOp = child
+
+		case *token.Element:
+			termType := c.checkTerm(child)
+
+			if pendingOp == nil {
+				result = termType
+			} else {
+				result = c.checkOperation(pendingOp, result, termType)
+				pendingOp = nil
+			}
+		}
+	}
+
+	return result
+}
+
+func (c *Checker) checkOperation(op *token.Token, left, right string) string {
+	switch op.Value {
+	case "+", "-", "*", "/":
+		c.expectNumeric(op, left, right)
+		return "int"
+
+	case "<", ">":
+		c.expectNumeric(op, left, right)
+		return "boolean"
+
+	case "=":
+		if !comparableTypes(left, right) {
+			c.typeErrorf(op.Span(), "cannot compare %s with %s", left, right)
+		}
+		return "boolean"
+
+	case "&", "|":
+		// These are logical on booleans, but bitwise on integers
+		switch {
+		case left == "boolean" && right == "boolean":
+			return "boolean"
+		case (isNumeric(left) || left == "") && (isNumeric(right) || right == ""):
+			return "int"
+		case left == "" || right == "":
+			return ""
+		}
+
+		c.typeErrorf(op.Span(), "operator %s cannot be applied to %s and %s", op.Value, left, right)
+		return ""
+	}
+
+	return ""
+}
+
+func (c *Checker) checkTerm(term *token.Element) string {
+	if term.FindChildElement("expressionList") != nil {
+		returnType := c.checkCall(term)
+		if returnType == "void" {
+			names := term.AllChildTokensByType("identifier")
+			c.typeErrorf(term.Span(), "void subroutine %s used in an expression", names[len(names)-1].Value)
+			return ""
+		}
+
+		return returnType
+	}
+
+	first, err := term.ChildAsToken(0)
+	if err != nil {
+		return ""
+	}
+
+	switch first.TokenType {
+	case "integerConstant":
+		if d := IntegerRangeError(first); d != nil {
+			c.diagnostics = append(c.diagnostics, *d)
+		}
+		return "int"
+
+	case "stringConstant":
+		return "String"
+
+	case "keyword":
+		switch first.Value {
+		case "true", "false":
+			return "boolean"
+		case "null":
+			return "null"
+		case "this":
+			return c.class.Name
+		}
+
+	case "identifier":
+		variableType := c.checkVariable(first)
+
+		// Array access
+		if index := term.FindChildElement("expression"); index != nil {
+			c.checkIndex(first, variableType, index)
+			return ""
+		}
+
+		return variableType
+
+	case "symbol":
+		// Bracketed expression
+		if expression := term.FindChildElement("expression"); expression != nil {
+			return c.checkExpression(expression)
+		}
+
+		operand := term.FindChildElement("term")
+		if operand == nil {
+			return ""
+		}
+
+		if first.Value == "-" && IsMinimumInteger(operand) {
+			return "int"
+		}
+
+		operandType := c.checkTerm(operand)
+
+		switch first.Value {
+		case "-":
+			c.expectNumeric(first, operandType)
+			return "int"
+		case "~":
+			if operandType != "boolean" && operandType != "" && !isNumeric(operandType) {
+				c.typeErrorf(first.Span(), "operator ~ cannot be applied to %s", operandType)
+			}
+			return operandType
+		}
+	}
+
+	return ""
+}
+
+// checkIndex checks an `array[index]` access
+func (c *Checker) checkIndex(array *token.Token, arrayType string, index *token.Element) {
+	if arrayType != "" && arrayType != "Array" {
+		c.typeErrorf(array.Span(), "%s has type %s, and can't be indexed like an Array", array.Value, arrayType)
+	}
+
+	c.expectType(index, c.checkExpression(index), "int", "array index")
+}
+
+// checkCall checks the qualifier and arguments of a subroutine call, and
+// returns the type of value the call returns. A qualifier may name either a
+// variable holding an object, or a class. Calls on an object, or unqualified
+// calls, which are on this, must be to methods, and calls on a class must not.
+func (c *Checker) checkCall(call *token.Element) string {
+	var identifiers []*token.Token
+	for _, child := range call.Children {
+		if t, ok := child.(*token.Token); ok && t.TokenType == "identifier" {
+			identifiers = append(identifiers, t)
+		}
+	}
+
+	if len(identifiers) == 0 {
+		return ""
+	}
+
+	class := c.class.Name
+	name := identifiers[len(identifiers)-1]
+	at := name
+	onObject := true
+
+	if len(identifiers) == 2 {
+		qualifier := identifiers[0]
+		at = qualifier
+
+		variable, isVariable := c.scope[qualifier.Value]
+		isClass := c.registry.Class(qualifier.Value) != nil || qualifier.Value == c.class.Name
+
+		switch {
+		case isVariable:
+			class = variable.Type
+		case isClass:
+			class = qualifier.Value
+			onObject = false
+		case isClassName(qualifier.Value):
+			c.errorf(qualifier.Span(), "unknown class %s", qualifier.Value)
+			class = ""
+		default:
+			c.errorf(qualifier.Span(), "undeclared variable %s", qualifier.Value)
+			class = ""
+		}
+	}
+
+	var argumentTypes []string
+	var arguments []*token.Element
+	if list := call.FindChildElement("expressionList"); list != nil {
+		arguments = list.AllChildElementsByTag("expression")
+		for _, expression := range arguments {
+			argumentTypes = append(argumentTypes, c.checkExpression(expression))
+		}
+	}
+
+	if class == "" {
+		return ""
+	}
+
+	if class != c.class.Name && c.registry.Class(class) == nil {
+		c.errorf(at.Span(), "unknown class %s", class)
+		return ""
+	}
+
+	subroutine := c.lookupSubroutine(class, name.Value)
+	if subroutine == nil {
+		c.errorf(name.Span(), "unknown subroutine %s.%s", class, name.Value)
+		return ""
+	}
+
+	switch {
+	case onObject && subroutine.Kind != "method":
+		c.errorf(name.Span(), "%s %s.%s cannot be called as a method", subroutine.Kind, class, subroutine.Name)
+	case !onObject && subroutine.Kind == "method":
+		c.errorf(name.Span(), "method %s.%s must be called on an object", class, subroutine.Name)
+	}
+
+	if expected := len(subroutine.Parameters); expected != len(arguments) {
+		noun := "arguments"
+		if expected == 1 {
+			noun = "argument"
+		}
+
+		c.errorf(name.Span(), "%s.%s expects %d %s, got %d", class, subroutine.Name, expected, noun, len(arguments))
+	}
+
+	for i, parameter := range subroutine.Parameters {
+		if i >= len(argumentTypes) {
+			break
+		}
+
+		if !assignable(parameter.Type, argumentTypes[i]) {
+			c.typeErrorf(arguments[i].Span(), "cannot pass %s as argument %s of %s.%s, which has type %s", argumentTypes[i], parameter.Name, class, subroutine.Name, parameter.Type)
+		}
+	}
+
+	if subroutine.Kind == "constructor" {
+		return class
+	}
+
+	return subroutine.ReturnType
+}
+
+func (c *Checker) lookupSubroutine(class, name string) *Subroutine {
+	if class == c.class.Name {
+		return c.class.Subroutines[name]
+	}
+
+	return c.registry.Subroutine(class, name)
+}
+
+// checkVariable checks that a name has been declared, and returns its type
+func (c *Checker) checkVariable(name *token.Token) string {
+	if variable, ok := c.scope[name.Value]; ok {
+		return variable.Type
+	}
+
+	if c.registry.Class(name.Value) != nil {
+		c.errorf(name.Span(), "class %s used as a variable", name.Value)
+		return ""
+	}
+
+	c.errorf(name.Span(), "undeclared variable %s", name.Value)
+	return ""
+}
+
+// isClassName reports whether name follows the Jack convention of starting
+// class names with a capital letter
+func isClassName(name string) bool {
+	for _, r := range name {
+		return unicode.IsUpper(r)
+	}
+
+	return false
+}
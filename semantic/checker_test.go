@@ -0,0 +1,261 @@
+package semantic
+
+import (
+	"liggi-go-jack-compiler/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// check collects every source into one registry, then checks the first
+func check(t *testing.T, sources ...string) []string {
+	t.Helper()
+
+	registry := NewRegistry()
+	main := parse(t, "Main.jack", sources[0])
+	registry.Collect(main)
+
+	for _, source := range sources[1:] {
+		registry.Collect(parse(t, "Other.jack", source))
+	}
+
+	var messages []string
+	for _, d := range NewChecker(registry).Check(main) {
+		messages = append(messages, d.Error())
+	}
+
+	return messages
+}
+
+func TestChecker_UndeclaredNames(t *testing.T) {
+	messages := check(t, `class Main {
+	field int count;
+
+	method void main(int n) {
+		var Array a;
+		let a[n] = count + total;
+		let missing = a[index];
+		do Output.printInt(-other);
+		do Helper.run(n);
+		do Unknown.run();
+		do thing.run();
+		do main(Helper);
+		return;
+	}
+}`, `class Helper {
+	function void run(int n) { return; }
+}`)
+
+	expected := []string{
+		"Main.jack:6:22: error: undeclared variable total",
+		"Main.jack:7:7: error: undeclared variable missing",
+		"Main.jack:7:19: error: undeclared variable index",
+		"Main.jack:8:23: error: undeclared variable other",
+		"Main.jack:10:6: error: unknown class Unknown",
+		"Main.jack:11:6: error: undeclared variable thing",
+		"Main.jack:12:11: error: class Helper used as a variable",
+	}
+
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestChecker_Types(t *testing.T) {
+	source := `class Main {
+	field int count;
+
+	method boolean main(int n, char c, boolean flag) {
+		var Array a;
+		var Point p;
+		let n = flag;
+		let n = c + 1;
+		let p = null;
+		let p = Point.new(n);
+		let flag = p.setX(1);
+		let count[0] = 1;
+		let a[flag] = 1;
+		if (n) { return flag; }
+		while (~flag) { let n = -c; }
+		let n = flag + 1;
+		let flag = (n < 3) & flag;
+		do Main.other(p, p);
+		return;
+	}
+
+	function int other(Point p, int x) {
+		return true;
+	}
+
+	function void done() {
+		return 1;
+	}
+}`
+	point := `class Point {
+	constructor Point new(int x) { return this; }
+	method void setX(int x) { return; }
+}`
+
+	expected := []string{
+		"Main.jack:7:11: warning: cannot assign boolean to n of type int",
+		"Main.jack:11:14: warning: void subroutine setX used in an expression",
+		"Main.jack:12:7: warning: count has type int, and can't be indexed like an Array",
+		"Main.jack:13:9: warning: array index must be int, not boolean",
+		"Main.jack:14:7: warning: if condition must be boolean, not int",
+		"Main.jack:16:16: warning: operator + cannot be applied to boolean",
+		"Main.jack:18:20: warning: cannot pass Point as argument x of Main.other, which has type int",
+		"Main.jack:19:3: warning: main must return a value of type boolean",
+		"Main.jack:23:10: warning: cannot return boolean from other, which returns int",
+		"Main.jack:27:10: warning: void done cannot return a value",
+	}
+
+	if diff := cmp.Diff(expected, check(t, source, point)); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestChecker_Strict(t *testing.T) {
+	registry := NewRegistry()
+	syntax := parse(t, "Main.jack", `class Main {
+	function void main() {
+		var int n;
+		let n = true;
+		return;
+	}
+}`)
+	registry.Collect(syntax)
+
+	checker := NewChecker(registry)
+	checker.Strict = true
+
+	diagnostics := checker.Check(syntax)
+	if !diagnostics.HasErrors() {
+		t.Errorf("expected type errors in strict mode, got %v", diagnostics)
+	}
+}
+
+// The stock example programs should all compile in strict mode
+func TestChecker_StrictTestCases(t *testing.T) {
+	directories, err := filepath.Glob("../test-cases/*")
+	if err != nil || len(directories) == 0 {
+		t.Fatalf("failed to find test-cases: %v", err)
+	}
+
+	for _, directory := range directories {
+		paths, err := filepath.Glob(filepath.Join(directory, "*.jack"))
+		if err != nil {
+			t.Fatalf("failed to list %s: %v", directory, err)
+		}
+
+		registry := NewRegistry()
+		var classes [][]token.Node
+		for _, path := range paths {
+			source, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read %s: %v", path, err)
+			}
+
+			syntax := parse(t, path, string(source))
+			registry.Collect(syntax)
+			classes = append(classes, syntax)
+		}
+
+		checker := NewChecker(registry)
+		checker.Strict = true
+
+		for _, syntax := range classes {
+			if diagnostics := checker.Check(syntax); diagnostics.HasErrors() {
+				t.Errorf("%s: expected no errors in strict mode, got %v", directory, diagnostics)
+			}
+		}
+	}
+}
+
+func TestChecker_OSCalls(t *testing.T) {
+	messages := check(t, `class Main {
+	function void main() {
+		var String s;
+		var int n;
+		let s = Keyboard.readLine("name? ");
+		let n = Keyboard.readLine("age? ");
+		do Output.printString(n);
+		do s.appendChar(Math.sqrt(n));
+		return;
+	}
+}`)
+
+	expected := []string{
+		"Main.jack:6:11: warning: cannot assign String to n of type int",
+		"Main.jack:7:25: warning: cannot pass int as argument s of Output.printString, which has type String",
+	}
+
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestChecker_IntegerRange(t *testing.T) {
+	messages := check(t, `class Main {
+	function void main() {
+		var int n;
+		let n = 32767;
+		let n = -32768;
+		let n = 32768;
+		let n = 1 - 99999;
+		return;
+	}
+}`)
+
+	expected := []string{
+		"Main.jack:6:11: error: integer constant 32768 is out of range 0..32767",
+		"Main.jack:7:15: error: integer constant 99999 is out of range 0..32767",
+	}
+
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestChecker_Calls(t *testing.T) {
+	messages := check(t, `class Main {
+	function void main() {
+		var Counter c;
+		var int n;
+		let c = Counter.new();
+		do c.add(Counter.zero());
+		do Output.printInt(1);
+		do Countr.zero();
+		do Counter.one();
+		do c.add(1, 2);
+		do Counter.add(1);
+		do c.zero();
+		do Output.printLine();
+		do Memory.poke(8000);
+		do n.run();
+		return;
+	}
+}`, `class Counter {
+	field int count;
+
+	constructor Counter new() { let count = 0; return this; }
+	method void add(int n) { let count = count + n; return; }
+	function int zero() { return 0; }
+}`)
+
+	expected := []string{
+		"Main.jack:8:6: error: unknown class Countr",
+		"Main.jack:9:14: error: unknown subroutine Counter.one",
+		"Main.jack:10:8: error: Counter.add expects 1 argument, got 2",
+		"Main.jack:11:14: error: method Counter.add must be called on an object",
+		"Main.jack:12:8: error: function Counter.zero cannot be called as a method",
+		"Main.jack:13:13: error: unknown subroutine Output.printLine",
+		"Main.jack:14:13: error: Memory.poke expects 2 arguments, got 1",
+		"Main.jack:15:6: error: unknown class int",
+	}
+
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
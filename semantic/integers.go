@@ -0,0 +1,34 @@
+package semantic
+
+import (
+	"liggi-go-jack-compiler/diagnostic"
+	"liggi-go-jack-compiler/token"
+	"strconv"
+)
+
+// MaxInteger is the largest integer constant Jack allows. The smallest int,
+// -32768, can only be written by negating 32768.
+const MaxInteger = 32767
+
+// IntegerRangeError returns a diagnostic if an integer constant is too big
+// for Jack, or nil if it's fine
+func IntegerRangeError(constant *token.Token) *diagnostic.Diagnostic {
+	value, err := strconv.Atoi(constant.Value)
+	if err == nil && value >= 0 && value <= MaxInteger {
+		return nil
+	}
+
+	d := diagnostic.Errorf(constant.Span(), "integer constant %s is out of range 0..%d", constant.Value, MaxInteger)
+	return &d
+}
+
+// IsMinimumInteger reports whether a term is the constant 32768, which is
+// allowed only as the operand of a unary minus
+func IsMinimumInteger(term *token.Element) bool {
+	if term == nil || len(term.Children) != 1 {
+		return false
+	}
+
+	constant, ok := term.Children[0].(*token.Token)
+	return ok && constant.TokenType == "integerConstant" && constant.Value == "32768"
+}
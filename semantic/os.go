@@ -0,0 +1,134 @@
+package semantic
+
+import (
+	"fmt"
+	"strings"
+)
+
+// osSignatures describes the standard Jack OS, one subroutine per line in the
+// same form it would be declared in Jack
+var osSignatures = map[string][]string{
+	"Math": {
+		"function void init()",
+		"function int abs(int x)",
+		"function int multiply(int x, int y)",
+		"function int divide(int x, int y)",
+		"function int min(int x, int y)",
+		"function int max(int x, int y)",
+		"function int sqrt(int x)",
+	},
+	"String": {
+		"constructor String new(int maxLength)",
+		"method void dispose()",
+		"method int length()",
+		"method char charAt(int j)",
+		"method void setCharAt(int j, char c)",
+		"method String appendChar(char c)",
+		"method void eraseLastChar()",
+		"method int intValue()",
+		"method void setInt(int val)",
+		"function char backSpace()",
+		"function char doubleQuote()",
+		"function char newLine()",
+	},
+	"Array": {
+		"function Array new(int size)",
+		"method void dispose()",
+	},
+	"Output": {
+		"function void init()",
+		"function void moveCursor(int i, int j)",
+		"function void printChar(char c)",
+		"function void printString(String s)",
+		"function void printInt(int i)",
+		"function void println()",
+		"function void backSpace()",
+	},
+	"Screen": {
+		"function void init()",
+		"function void clearScreen()",
+		"function void setColor(boolean b)",
+		"function void drawPixel(int x, int y)",
+		"function void drawLine(int x1, int y1, int x2, int y2)",
+		"function void drawRectangle(int x1, int y1, int x2, int y2)",
+		"function void drawCircle(int x, int y, int r)",
+	},
+	"Keyboard": {
+		"function void init()",
+		"function char keyPressed()",
+		"function char readChar()",
+		"function String readLine(String message)",
+		"function int readInt(String message)",
+	},
+	"Memory": {
+		"function void init()",
+		"function int peek(int address)",
+		"function void poke(int address, int value)",
+		"function Array alloc(int size)",
+		"function void deAlloc(Array o)",
+	},
+	"Sys": {
+		"function void init()",
+		"function void halt()",
+		"function void error(int errorCode)",
+		"function void wait(int duration)",
+	},
+}
+
+// OSClasses returns a fresh description of every class in the Jack OS
+func OSClasses() []*Class {
+	var classes []*Class
+
+	for name, signatures := range osSignatures {
+		class := &Class{
+			Name:        name,
+			Subroutines: map[string]*Subroutine{},
+			Builtin:     true,
+		}
+
+		for _, signature := range signatures {
+			subroutine := parseSignature(name, signature)
+			class.Subroutines[subroutine.Name] = subroutine
+		}
+
+		classes = append(classes, class)
+	}
+
+	return classes
+}
+
+// parseSignature reads a line of the form `kind type name(type name, ...)`
+func parseSignature(class, signature string) *Subroutine {
+	open := strings.Index(signature, "(")
+	if open < 0 || !strings.HasSuffix(signature, ")") {
+		panic(fmt.Sprintf("malformed OS signature: %s", signature))
+	}
+
+	header := strings.Fields(signature[:open])
+	if len(header) != 3 {
+		panic(fmt.Sprintf("malformed OS signature: %s", signature))
+	}
+
+	subroutine := &Subroutine{
+		Class:      class,
+		Kind:       header[0],
+		ReturnType: header[1],
+		Name:       header[2],
+	}
+
+	parameters := strings.TrimSpace(signature[open+1 : len(signature)-1])
+	if parameters == "" {
+		return subroutine
+	}
+
+	for _, parameter := range strings.Split(parameters, ",") {
+		fields := strings.Fields(parameter)
+		if len(fields) != 2 {
+			panic(fmt.Sprintf("malformed OS signature: %s", signature))
+		}
+
+		subroutine.Parameters = append(subroutine.Parameters, Parameter{Type: fields[0], Name: fields[1]})
+	}
+
+	return subroutine
+}
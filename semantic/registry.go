@@ -0,0 +1,212 @@
+package semantic
+
+import (
+	"liggi-go-jack-compiler/diagnostic"
+	"liggi-go-jack-compiler/token"
+)
+
+// Registry holds the signature of every class in a program, so that one file
+// can be checked against the declarations in another
+type Registry struct {
+	Classes map[string]*Class
+}
+
+type Class struct {
+	Name        string
+	Variables   []Variable
+	Subroutines map[string]*Subroutine
+	Span        token.Span
+
+	// Builtin classes come from the Jack OS. A program may declare its own
+	// version of one, which then replaces the builtin.
+	Builtin bool
+}
+
+type Variable struct {
+	Name string
+	Type string
+	Kind string // "static" or "field"
+	Span token.Span
+}
+
+type Parameter struct {
+	Name string
+	Type string
+}
+
+type Subroutine struct {
+	Class      string
+	Name       string
+	Kind       string // "function", "method" or "constructor"
+	ReturnType string
+	Parameters []Parameter
+	Span       token.Span
+}
+
+func NewRegistry() *Registry {
+	r := &Registry{
+		Classes: map[string]*Class{},
+	}
+
+	for _, class := range OSClasses() {
+		r.Classes[class.Name] = class
+	}
+
+	return r
+}
+
+func (r *Registry) Class(name string) *Class {
+	return r.Classes[name]
+}
+
+func (r *Registry) Subroutine(class, name string) *Subroutine {
+	c := r.Class(class)
+	if c == nil {
+		return nil
+	}
+
+	return c.Subroutines[name]
+}
+
+// Collect adds every class declared in a parsed file to the registry
+func (r *Registry) Collect(nodes []token.Node) diagnostic.List {
+	var diagnostics diagnostic.List
+
+	for _, node := range nodes {
+		element, ok := node.(*token.Element)
+		if !ok || element.Tag != "class" {
+			continue
+		}
+
+		nameToken := element.FindChildToken("identifier")
+		if nameToken == nil {
+			continue
+		}
+
+		if existing := r.Class(nameToken.Value); existing != nil && !existing.Builtin {
+			diagnostics = append(diagnostics, diagnostic.Errorf(nameToken.Span(), "class %s is already declared at %s", nameToken.Value, existing.Span))
+			continue
+		}
+
+		class, classDiagnostics := collectClass(element, nameToken.Value)
+		class.Span = nameToken.Span()
+
+		r.Classes[class.Name] = class
+		diagnostics = append(diagnostics, classDiagnostics...)
+	}
+
+	return diagnostics
+}
+
+func collectClass(element *token.Element, name string) (*Class, diagnostic.List) {
+	var diagnostics diagnostic.List
+
+	class := &Class{
+		Name:        name,
+		Subroutines: map[string]*Subroutine{},
+	}
+
+	for _, dec := range element.AllChildElementsByTag("classVarDec") {
+		class.Variables = append(class.Variables, collectVariables(dec)...)
+	}
+
+	for _, dec := range element.AllChildElementsByTag("subroutineDec") {
+		subroutine := collectSubroutine(dec, name)
+		if subroutine == nil {
+			continue
+		}
+
+		if existing, ok := class.Subroutines[subroutine.Name]; ok {
+			diagnostics = append(diagnostics, diagnostic.Errorf(subroutine.Span, "subroutine %s.%s is already declared at %s", name, subroutine.Name, existing.Span))
+			continue
+		}
+
+		class.Subroutines[subroutine.Name] = subroutine
+	}
+
+	return class, diagnostics
+}
+
+// collectVariables reads a `static`/`field`/`var` declaration, which always
+// has the shape `kind type name (, name)* ;`
+func collectVariables(dec *token.Element) []Variable {
+	var variables []Variable
+
+	kind, err := dec.ChildAsToken(0)
+	if err != nil {
+		return nil
+	}
+
+	typ, err := dec.ChildAsToken(1)
+	if err != nil {
+		return nil
+	}
+
+	// Everything after the type is either a name or a separator
+	for _, child := range dec.Children[2:] {
+		name, ok := child.(*token.Token)
+		if !ok || name.TokenType != "identifier" {
+			continue
+		}
+
+		variables = append(variables, Variable{
+			Name: name.Value,
+			Type: typ.Value,
+			Kind: kind.Value,
+			Span: name.Span(),
+		})
+	}
+
+	return variables
+}
+
+func collectSubroutine(dec *token.Element, class string) *Subroutine {
+	kind, err := dec.ChildAsToken(0)
+	if err != nil {
+		return nil
+	}
+
+	returnType, err := dec.ChildAsToken(1)
+	if err != nil {
+		return nil
+	}
+
+	name, err := dec.ChildAsToken(2)
+	if err != nil {
+		return nil
+	}
+
+	return &Subroutine{
+		Class:      class,
+		Name:       name.Value,
+		Kind:       kind.Value,
+		ReturnType: returnType.Value,
+		Parameters: collectParameters(dec.FindChildElement("parameterList")),
+		Span:       name.Span(),
+	}
+}
+
+// collectParameters reads a parameter list, which alternates between types
+// and names separated by commas
+func collectParameters(list *token.Element) []Parameter {
+	var parameters []Parameter
+	if list == nil {
+		return nil
+	}
+
+	var typesAndNames []*token.Token
+	for _, child := range list.AllChildTokens() {
+		if child.TokenType == "keyword" || child.TokenType == "identifier" {
+			typesAndNames = append(typesAndNames, child)
+		}
+	}
+
+	for i := 0; i+1 < len(typesAndNames); i += 2 {
+		parameters = append(parameters, Parameter{
+			Type: typesAndNames[i].Value,
+			Name: typesAndNames[i+1].Value,
+		})
+	}
+
+	return parameters
+}
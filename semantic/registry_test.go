@@ -0,0 +1,114 @@
+package semantic
+
+import (
+	"liggi-go-jack-compiler/parser"
+	"liggi-go-jack-compiler/token"
+	"liggi-go-jack-compiler/tokeniser"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func parse(t *testing.T, fileName, source string) []token.Node {
+	t.Helper()
+
+	tokens, err := tokeniser.NewTokeniser(strings.NewReader(source), fileName).Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected tokeniser error: %v", err)
+	}
+
+	syntax, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("unexpected parser error: %v", err)
+	}
+
+	return syntax
+}
+
+func TestRegistry_Collect(t *testing.T) {
+	registry := NewRegistry()
+
+	diagnostics := registry.Collect(parse(t, "Point.jack", `
+class Point {
+	static int count;
+	field int x, y;
+
+	constructor Point new(int ax, int ay) { return this; }
+	method int getX() { return x; }
+}`))
+	if len(diagnostics) > 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	expected := &Class{
+		Name: "Point",
+		Variables: []Variable{
+			{Name: "count", Type: "int", Kind: "static"},
+			{Name: "x", Type: "int", Kind: "field"},
+			{Name: "y", Type: "int", Kind: "field"},
+		},
+		Subroutines: map[string]*Subroutine{
+			"new": {
+				Class:      "Point",
+				Name:       "new",
+				Kind:       "constructor",
+				ReturnType: "Point",
+				Parameters: []Parameter{{Name: "ax", Type: "int"}, {Name: "ay", Type: "int"}},
+			},
+			"getX": {Class: "Point", Name: "getX", Kind: "method", ReturnType: "int"},
+		},
+	}
+
+	ignoreSpans := cmpopts.IgnoreTypes(token.Span{})
+	if diff := cmp.Diff(expected, registry.Class("Point"), ignoreSpans); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestRegistry_Duplicates(t *testing.T) {
+	registry := NewRegistry()
+
+	registry.Collect(parse(t, "A.jack", "class A { function void f() { return; } }"))
+	diagnostics := registry.Collect(parse(t, "B.jack", "class A { function void f() { return; } }"))
+	diagnostics = append(diagnostics, registry.Collect(parse(t, "C.jack", "class C { function void f() { return; } method void f() { return; } }"))...)
+
+	var messages []string
+	for _, d := range diagnostics {
+		messages = append(messages, d.Error())
+	}
+
+	expected := []string{
+		"B.jack:1:7: error: class A is already declared at A.jack:1:7",
+		"C.jack:1:53: error: subroutine C.f is already declared at C.jack:1:25",
+	}
+	if diff := cmp.Diff(expected, messages); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestRegistry_OSClasses(t *testing.T) {
+	registry := NewRegistry()
+
+	expected := &Subroutine{
+		Class:      "String",
+		Name:       "appendChar",
+		Kind:       "method",
+		ReturnType: "String",
+		Parameters: []Parameter{{Name: "c", Type: "char"}},
+	}
+	if diff := cmp.Diff(expected, registry.Subroutine("String", "appendChar")); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+
+	// A program can supply its own version of an OS class
+	diagnostics := registry.Collect(parse(t, "Math.jack", "class Math { function int double(int x) { return x + x; } }"))
+	if len(diagnostics) > 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	if registry.Subroutine("Math", "double") == nil || registry.Subroutine("Math", "multiply") != nil {
+		t.Errorf("expected Math to be replaced by the program's own class")
+	}
+}
@@ -0,0 +1,70 @@
+package semantic
+
+import (
+	"liggi-go-jack-compiler/diagnostic"
+	"liggi-go-jack-compiler/token"
+)
+
+// typeErrorf reports a type problem, which is only an error in strict mode
+func (c *Checker) typeErrorf(span token.Span, format string, args ...interface{}) {
+	if c.Strict {
+		c.diagnostics = append(c.diagnostics, diagnostic.Errorf(span, format, args...))
+	} else {
+		c.diagnostics = append(c.diagnostics, diagnostic.Warningf(span, format, args...))
+	}
+}
+
+func (c *Checker) expectType(expression *token.Element, actual, expected, description string) {
+	if expression == nil || assignable(expected, actual) {
+		return
+	}
+
+	c.typeErrorf(expression.Span(), "%s must be %s, not %s", description, expected, actual)
+}
+
+func (c *Checker) expectNumeric(op *token.Token, operands ...string) {
+	for _, operand := range operands {
+		if operand != "" && !isNumeric(operand) {
+			c.typeErrorf(op.Span(), "operator %s cannot be applied to %s", op.Value, operand)
+			return
+		}
+	}
+}
+
+// isNumeric reports whether a type behaves as a number. Jack characters are
+// just their character codes, so they can be used anywhere an int can.
+func isNumeric(typ string) bool {
+	return typ == "int" || typ == "char"
+}
+
+func isPrimitive(typ string) bool {
+	return isNumeric(typ) || typ == "boolean" || typ == "void"
+}
+
+// assignable reports whether a value of type from can be stored somewhere of
+// type to. An empty type is one that couldn't be worked out, and is assumed
+// to be fine.
+func assignable(to, from string) bool {
+	switch {
+	case to == "" || from == "" || to == from:
+		return true
+	case isNumeric(to) && isNumeric(from):
+		return true
+	case from == "null":
+		return !isPrimitive(to)
+	case (to == "Array" && isNumeric(from)) || (from == "Array" && isNumeric(to)):
+		// An Array is just an address, which Jack programs freely treat as
+		// an int and back
+		return true
+	case to == "Array" || from == "Array":
+		// Arrays are Jack's raw pointers, so any object can be treated as
+		// one, and an Array can stand in for any object
+		return !isPrimitive(to) && !isPrimitive(from)
+	}
+
+	return false
+}
+
+func comparableTypes(a, b string) bool {
+	return assignable(a, b) || assignable(b, a)
+}
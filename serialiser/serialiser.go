@@ -0,0 +1,61 @@
+package serialiser
+
+import (
+	"fmt"
+	"liggi-go-jack-compiler/token"
+	"strings"
+)
+
+var escaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&quot;",
+)
+
+// TokensXML writes a token stream in the format of the nand2tetris project 10
+// `T.xml` comparison files
+func TokensXML(tokens []token.Token) string {
+	var sb strings.Builder
+
+	sb.WriteString("<tokens>\n")
+	for _, t := range tokens {
+		writeToken(&sb, &t, "")
+	}
+	sb.WriteString("</tokens>\n")
+
+	return sb.String()
+}
+
+// TreeXML writes a parse tree in the format of the nand2tetris project 10
+// `.xml` comparison files
+func TreeXML(nodes []token.Node) string {
+	var sb strings.Builder
+
+	for _, node := range nodes {
+		writeNode(&sb, node, "")
+	}
+
+	return sb.String()
+}
+
+func writeNode(sb *strings.Builder, node token.Node, indent string) {
+	switch node := node.(type) {
+	case *token.Token:
+		writeToken(sb, node, indent)
+	case *token.Element:
+		writeElement(sb, node, indent)
+	}
+}
+
+func writeElement(sb *strings.Builder, element *token.Element, indent string) {
+	fmt.Fprintf(sb, "%s<%s>\n", indent, element.Tag)
+	for _, child := range element.Children {
+		writeNode(sb, child, indent+"  ")
+	}
+	fmt.Fprintf(sb, "%s</%s>\n", indent, element.Tag)
+}
+
+func writeToken(sb *strings.Builder, t *token.Token, indent string) {
+	fmt.Fprintf(sb, "%s<%s> %s </%s>\n", indent, t.TokenType, escaper.Replace(t.Value), t.TokenType)
+}
@@ -0,0 +1,100 @@
+package serialiser
+
+import (
+	"liggi-go-jack-compiler/parser"
+	"liggi-go-jack-compiler/tokeniser"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const source = `class Main {
+  function void main() {
+    if (x < "a&b") { return; }
+  }
+}`
+
+func TestTokensXML(t *testing.T) {
+	tokens, err := tokeniser.NewTokeniser(strings.NewReader(`if (x < "a&b") {}`)).Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected tokeniser error: %v", err)
+	}
+
+	expected := `<tokens>
+<keyword> if </keyword>
+<symbol> ( </symbol>
+<identifier> x </identifier>
+<symbol> &lt; </symbol>
+<stringConstant> a&amp;b </stringConstant>
+<symbol> ) </symbol>
+<symbol> { </symbol>
+<symbol> } </symbol>
+</tokens>
+`
+
+	if diff := cmp.Diff(strings.Split(expected, "\n"), strings.Split(TokensXML(tokens), "\n")); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestTreeXML(t *testing.T) {
+	tokens, err := tokeniser.NewTokeniser(strings.NewReader(source)).Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected tokeniser error: %v", err)
+	}
+
+	syntax, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("unexpected parser error: %v", err)
+	}
+
+	expected := `<class>
+  <keyword> class </keyword>
+  <identifier> Main </identifier>
+  <symbol> { </symbol>
+  <subroutineDec>
+    <keyword> function </keyword>
+    <keyword> void </keyword>
+    <identifier> main </identifier>
+    <symbol> ( </symbol>
+    <parameterList>
+    </parameterList>
+    <symbol> ) </symbol>
+    <subroutineBody>
+      <symbol> { </symbol>
+      <statements>
+        <ifStatement>
+          <keyword> if </keyword>
+          <symbol> ( </symbol>
+          <expression>
+            <term>
+              <identifier> x </identifier>
+            </term>
+            <symbol> &lt; </symbol>
+            <term>
+              <stringConstant> a&amp;b </stringConstant>
+            </term>
+          </expression>
+          <symbol> ) </symbol>
+          <symbol> { </symbol>
+          <statements>
+            <returnStatement>
+              <keyword> return </keyword>
+              <symbol> ; </symbol>
+            </returnStatement>
+          </statements>
+          <symbol> } </symbol>
+        </ifStatement>
+      </statements>
+      <symbol> } </symbol>
+    </subroutineBody>
+  </subroutineDec>
+  <symbol> } </symbol>
+</class>
+`
+
+	if diff := cmp.Diff(strings.Split(expected, "\n"), strings.Split(TreeXML(syntax), "\n")); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
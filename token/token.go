@@ -1,14 +1,56 @@
 package token
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
+
+// Keywords is the complete set of reserved words in Jack. None of them can be
+// used as an identifier.
+var Keywords = []string{
+	"class", "constructor", "function", "method", "field", "static", "var",
+	"int", "char", "boolean", "void", "true", "false", "null", "this",
+	"let", "do", "if", "else", "while", "return",
+}
 
 type TokenMatchable interface {
 	Match(token Token) bool
 }
 
+// Position identifies a single point in a source file. Lines and columns
+// are 1-based, Offset is the 0-based byte offset from the start of the file.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+	Offset int
+}
+
+// Span covers the source between Start (inclusive) and End (exclusive).
+type Span struct {
+	Start Position
+	End   Position
+}
+
 type Token struct {
 	TokenType string
 	Value     string
+	Start     Position
+	End       Position
+
+	// The rest are only filled in by a tokeniser that keeps trivia. Raw is
+	// the token as written, which for a string constant differs from its
+	// decoded Value.
+	Raw            string
+	LeadingTrivia  []Trivia
+	TrailingTrivia []Trivia
+}
+
+// Trivia is source text that isn't part of any token. Its Kind is one of
+// "whitespace", "lineComment", "blockComment" or "docComment".
+type Trivia struct {
+	Kind string
+	Text string
 }
 
 type PossibleTokens struct {
@@ -17,15 +59,96 @@ type PossibleTokens struct {
 
 type Node interface {
 	isNode()
+	Span() Span
 }
 
 type Element struct {
 	Tag      string
 	Children []Node
+	Start    Position
+	End      Position
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.File != "" {
+			return p.File
+		}
+
+		return "-"
+	}
+
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+func (s Span) String() string {
+	return s.Start.String()
 }
 
 func (t Token) isNode() {}
 
+func (t Token) Span() Span {
+	return Span{Start: t.Start, End: t.End}
+}
+
+func (t Token) String() string {
+	return fmt.Sprintf("{%s %s}", t.TokenType, t.Value)
+}
+
+// FullText is the token as written along with its trivia. Joining the full
+// text of every token from a tokeniser that keeps trivia gives back the
+// source exactly.
+func (t Token) FullText() string {
+	var text strings.Builder
+
+	for _, trivia := range t.LeadingTrivia {
+		text.WriteString(trivia.Text)
+	}
+
+	text.WriteString(t.Raw)
+
+	for _, trivia := range t.TrailingTrivia {
+		text.WriteString(trivia.Text)
+	}
+
+	return text.String()
+}
+
+func (e *Element) Span() Span {
+	return Span{Start: e.Start, End: e.End}
+}
+
+// UpdateSpan recalculates the span of the element, and every element below
+// it, from the first and last tokens it contains.
+func (e *Element) UpdateSpan() {
+	e.Start = Position{}
+	e.End = Position{}
+
+	for _, child := range e.Children {
+		if element, ok := child.(*Element); ok {
+			element.UpdateSpan()
+		}
+
+		span := child.Span()
+		if !span.Start.IsValid() {
+			continue
+		}
+
+		if !e.Start.IsValid() {
+			e.Start = span.Start
+		}
+		e.End = span.End
+	}
+}
+
 func (e Element) ChildAsToken(index int) (*Token, error) {
 	if index >= len(e.Children) || index < 0 {
 		return nil, fmt.Errorf("invalid index %d", index)
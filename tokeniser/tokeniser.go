@@ -5,194 +5,538 @@ import (
 	"fmt"
 	"io"
 	"liggi-go-jack-compiler/token"
-	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
+// Tokeniser reads Jack source a character at a time, classifying each one with
+// plain comparisons and table lookups, since lexing is on the hot path of
+// compiling a large program
 type Tokeniser struct {
-	scanner      *bufio.Scanner
-	token_buffer rune
+	reader  *bufio.Reader
+	readErr error
+
+	// lookahead holds tokens that have been peeked at but not yet read, and
+	// err the error that stopped tokenising, if any
+	lookahead []Token
+	err       error
+
+	// position is where the next character will be read from, current is
+	// where the most recently read character started
+	position token.Position
+	current  token.Position
+
+	// text collects the characters of the token being read, and is reused
+	// from one token to the next
+	text []byte
+
+	// With keepTrivia set, raw records every byte read since it was last
+	// taken, and ended is set once the endOfFile token has been returned
+	keepTrivia bool
+	raw        []byte
+	ended      bool
 }
 
 type Token = token.Token
 
-func NewTokeniser(r io.Reader) *Tokeniser {
-	scanner := bufio.NewScanner(r)
-	scanner.Split(bufio.ScanRunes)
-	return &Tokeniser{scanner: scanner}
+// Error is a problem with the source text itself, such as an unterminated
+// string, found at Position. Err is set when the source couldn't be read.
+type Error struct {
+	Position token.Position
+	Message  string
+	Err      error
 }
 
-func (t *Tokeniser) Scan() bool {
-	if t.token_buffer != 0 {
-		return true
-	}
+func errorAt(position token.Position, format string, args ...interface{}) *Error {
+	return &Error{Position: position, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Position, e.Message)
+}
 
-	return t.scanner.Scan()
+func (e *Error) Unwrap() error {
+	return e.Err
 }
 
-func (t *Tokeniser) Text() string {
-	if t.token_buffer != 0 {
-		token := string(t.token_buffer)
-		t.token_buffer = 0
-		return string(token)
+// eof is returned by next and peek once the input has run out
+const eof = -1
+
+func NewTokeniser(r io.Reader, fileName ...string) *Tokeniser {
+	position := token.Position{Line: 1, Column: 1}
+	if len(fileName) > 0 {
+		position.File = fileName[0]
 	}
 
-	return t.scanner.Text()
+	return &Tokeniser{reader: bufio.NewReader(r), position: position}
+}
+
+// KeepTrivia makes the tokeniser keep the whitespace and comments around each
+// token, rather than skipping them, so that the source can be rebuilt exactly.
+// Each token also has its Raw text, and the last token is an endOfFile token
+// holding whatever trivia follows the final real token.
+//
+// Trivia up to and including the end of the line a token finishes on trails
+// that token. Everything else leads the token after it.
+func (t *Tokeniser) KeepTrivia() *Tokeniser {
+	t.keepTrivia = true
+	return t
 }
 
-func (t *Tokeniser) Peek() rune {
-	if t.token_buffer == 0 {
-		if !t.scanner.Scan() {
-			return 0
+// next reads the next character, or returns eof. Errors reading the input are
+// kept to be returned once tokens run out.
+func (t *Tokeniser) next() rune {
+	char, size, err := t.reader.ReadRune()
+	if err != nil {
+		if err != io.EOF {
+			t.readErr = err
 		}
+		return eof
+	}
 
-		t.token_buffer = rune(t.Text()[0])
+	if t.keepTrivia {
+		if char == utf8.RuneError && size == 1 {
+			// Keep the invalid byte itself, not the replacement character
+			t.reader.UnreadRune()
+			b, _ := t.reader.ReadByte()
+			t.raw = append(t.raw, b)
+		} else {
+			t.raw = utf8.AppendRune(t.raw, char)
+		}
+	}
+
+	t.current = t.position
+	t.position.Offset += size
+
+	if char == '\n' {
+		t.position.Line++
+		t.position.Column = 1
+	} else {
+		t.position.Column++
 	}
 
-	return t.token_buffer
+	return char
 }
 
-func (t *Tokeniser) ScanUntilNot(exp string) string {
-	regex := regexp.MustCompile(exp)
+// peek returns the next character without reading it, or eof
+func (t *Tokeniser) peek() rune {
+	b, err := t.reader.Peek(1)
+	if err != nil {
+		return eof
+	}
+
+	if b[0] < utf8.RuneSelf {
+		return rune(b[0])
+	}
+
+	char, _, err := t.reader.ReadRune()
+	if err != nil {
+		return eof
+	}
+	t.reader.UnreadRune()
 
-	output := ""
+	return char
+}
 
-	// If the first character we look at stops matching, we don't need to scan any further
-	immediateNextChar := string(t.Peek())
-	if !regex.MatchString(immediateNextChar) {
-		return ""
+// scanWhile reads the rest of a token that started with first, for as long
+// as the characters that follow match
+func (t *Tokeniser) scanWhile(first rune, matches func(rune) bool) []byte {
+	t.text = utf8.AppendRune(t.text[:0], first)
+
+	for matches(t.peek()) {
+		t.text = utf8.AppendRune(t.text, t.next())
 	}
 
-	for t.Scan() {
-		currentChar := t.Text()
-		nextChar := string(t.Peek())
+	return t.text
+}
 
-		output += currentChar
+// skipLine skips to the end of the line, leaving the newline to be read next
+func (t *Tokeniser) skipLine() {
+	for char := t.peek(); char != '\n' && char != eof; char = t.peek() {
+		t.next()
+	}
+}
 
-		if !regex.MatchString(nextChar) {
-			break
+// Tokenise reads every token from the input
+func (t *Tokeniser) Tokenise() ([]Token, error) {
+	tokens := []Token{}
+
+	for {
+		token, err := t.Next()
+		if err == io.EOF {
+			return tokens, nil
+		}
+		if err != nil {
+			return nil, err
 		}
-	}
 
-	return output
+		tokens = append(tokens, token)
+	}
 }
 
-func (t *Tokeniser) ScanUntil(exp string, matches bool, charsToMatch ...int) string {
-	var scanAheadBy int
-	if len(charsToMatch) == 0 {
-		scanAheadBy = 1
-	} else {
-		scanAheadBy = charsToMatch[0]
+// Next reads the next token, returning io.EOF once there are none left. After
+// an error, every later call returns the same error.
+func (t *Tokeniser) Next() (Token, error) {
+	if len(t.lookahead) > 0 {
+		token := t.lookahead[0]
+		t.lookahead = t.lookahead[1:]
+		return token, nil
+	}
+
+	if t.err != nil {
+		return Token{}, t.err
 	}
 
-	regex := regexp.MustCompile(exp)
+	token, err := t.scan()
+	if err != nil {
+		t.err = err
+	}
 
-	output := ""
+	return token, err
+}
 
-	for t.Scan() {
-		firstChar := t.Text()
-		secondChar := string(t.Peek())
+// Peek returns the token n places ahead without reading it, so Peek(0) is the
+// token Next will return. Only the tokens looked at are held in memory. A
+// negative n is an error, and leaves the tokeniser as it was.
+func (t *Tokeniser) Peek(n int) (Token, error) {
+	if n < 0 {
+		return Token{}, fmt.Errorf("cannot peek %d tokens ahead", n)
+	}
 
-		if scanAheadBy == 1 && regex.MatchString(firstChar) == matches {
-			break
+	for len(t.lookahead) <= n {
+		if t.err != nil {
+			return Token{}, t.err
 		}
 
-		if scanAheadBy == 2 && regex.MatchString(firstChar+secondChar) == matches {
-			t.Text()
-			break
+		token, err := t.scan()
+		if err != nil {
+			t.err = err
+			return Token{}, err
 		}
 
-		output += firstChar
+		t.lookahead = append(t.lookahead, token)
 	}
 
-	return output
+	return t.lookahead[n], nil
 }
 
-func (t *Tokeniser) Tokenise() ([]Token, error) {
-	tokens := []Token{}
+// scan reads a token from the input, along with its trivia if it's being kept
+func (t *Tokeniser) scan() (Token, error) {
+	if !t.keepTrivia {
+		return t.scanToken()
+	}
+
+	if t.ended {
+		return Token{}, io.EOF
+	}
+
+	leading, err := t.scanTrivia(false)
+	if err != nil {
+		return Token{}, err
+	}
+
+	token, err := t.scanToken()
+	if err == io.EOF {
+		t.ended = true
+		token = Token{TokenType: "endOfFile", Start: t.position, End: t.position}
+	} else if err != nil {
+		return Token{}, err
+	}
+
+	token.Raw = t.takeRaw()
+	token.LeadingTrivia = leading
+
+	if !t.ended {
+		token.TrailingTrivia, err = t.scanTrivia(true)
+		if err != nil {
+			return Token{}, err
+		}
+	}
+
+	return token, nil
+}
 
-	for t.Scan() {
-		text := t.Text()
-		char := rune(text[0])
+// scanTrivia reads whitespace and comments up to the next token. Trailing
+// trivia stops after the end of the line.
+func (t *Tokeniser) scanTrivia(trailing bool) ([]token.Trivia, error) {
+	var trivia []token.Trivia
+	t.takeRaw()
+
+	for {
+		char := t.peek()
+
+		switch {
+		case isWhitespace(char):
+			for isWhitespace(t.peek()) {
+				if t.next() == '\n' && trailing {
+					return append(trivia, token.Trivia{Kind: "whitespace", Text: t.takeRaw()}), nil
+				}
+			}
+
+			trivia = append(trivia, token.Trivia{Kind: "whitespace", Text: t.takeRaw()})
+
+		case char == '/' && t.peekComment():
+			t.next()
+
+			kind := "lineComment"
+			if t.peek() == '/' {
+				t.skipLine()
+			} else {
+				kind = "blockComment"
+				if err := t.skipBlockComment(t.current); err != nil {
+					return nil, err
+				}
+			}
+
+			text := t.takeRaw()
+			if strings.HasPrefix(text, "/**") && text != "/**/" {
+				kind = "docComment"
+			}
+
+			trivia = append(trivia, token.Trivia{Kind: kind, Text: text})
+
+		default:
+			return trivia, nil
+		}
+	}
+}
+
+// peekComment reports whether the next two characters start a comment
+func (t *Tokeniser) peekComment() bool {
+	b, err := t.reader.Peek(2)
+	return err == nil && (isSingleLineComment(rune(b[0]), rune(b[1])) || isMultiLineComment(rune(b[0]), rune(b[1])))
+}
+
+// takeRaw returns the text read since it was last called
+func (t *Tokeniser) takeRaw() string {
+	raw := string(t.raw)
+	t.raw = t.raw[:0]
+
+	return raw
+}
+
+// scanToken reads a token from the input, skipping whitespace and comments
+func (t *Tokeniser) scanToken() (Token, error) {
+	for char := t.next(); char != eof; char = t.next() {
+		start := t.current
 
 		switch {
 		case isWhitespace(char):
 			continue
-		case isSingleLineComment(char, t.Peek()):
-			t.ScanUntil(`\n`, true)
+		case isSingleLineComment(char, t.peek()):
+			t.skipLine()
 			continue
-		case isMultiLineComment(char, t.Peek()):
-			t.ScanUntil(`\*/`, true, 2)
+		case isMultiLineComment(char, t.peek()):
+			if err := t.skipBlockComment(start); err != nil {
+				return Token{}, err
+			}
 			continue
 		case isSymbol(char):
-			token := Token{TokenType: "symbol", Value: string(char)}
-			tokens = append(tokens, token)
+			token := Token{TokenType: "symbol", Value: symbols[char]}
+			return t.positioned(token, start), nil
 		case isDigit(char):
-			integer_const := string(char) + t.ScanUntilNot(`\d`)
+			integer_const := string(t.scanWhile(char, isDigit))
 			token := Token{TokenType: "integerConstant", Value: integer_const}
 
-			tokens = append(tokens, token)
+			return t.positioned(token, start), nil
 		case char == '"':
-			string_const := t.ScanUntil(`"`, true)
+			string_const, err := t.scanString(start)
+			if err != nil {
+				return Token{}, err
+			}
+
 			token := Token{TokenType: "stringConstant", Value: string_const}
 
-			tokens = append(tokens, token)
+			return t.positioned(token, start), nil
 		case isValidIdentifier(char):
-			str := string(char) + t.ScanUntilNot(`[a-zA-Z0-9_]`)
+			text := t.scanWhile(char, isIdentifierCharacter)
 
-			if isKeyword(str) {
-				token := Token{TokenType: "keyword", Value: str}
-				tokens = append(tokens, token)
-			} else {
-				token := Token{TokenType: "identifier", Value: str}
-				tokens = append(tokens, token)
+			// Converting the text to look it up doesn't allocate, and
+			// keywords then share a single copy of their string
+			if keyword, ok := keywords[string(text)]; ok {
+				token := Token{TokenType: "keyword", Value: keyword}
+				return t.positioned(token, start), nil
 			}
+
+			token := Token{TokenType: "identifier", Value: string(text)}
+			return t.positioned(token, start), nil
 		default:
-			// Return an error
-			return nil, fmt.Errorf("unrecognised character: %s", string(char))
+			return Token{}, errorAt(start, "unrecognised character: %s", string(char))
 		}
 	}
 
-	return tokens, nil
-}
+	if t.readErr != nil {
+		return Token{}, &Error{Position: t.position, Message: t.readErr.Error(), Err: t.readErr}
+	}
 
-func isDigit(char rune) bool {
-	return regexp.MustCompile(`\d`).MatchString(string(char))
+	return Token{}, io.EOF
 }
 
-func isValidIdentifier(char rune) bool {
-	return regexp.MustCompile(`[a-zA-Z]`).MatchString(string(char))
+// The Hack character set is printable ASCII, plus key codes from 128 for
+// newline, backspace, the arrow keys and so on
+const (
+	hackNewLine = 128
+	hackMaxKey  = 152
+)
+
+// skipBlockComment skips the rest of a /* comment */, given where it started
+func (t *Tokeniser) skipBlockComment(start token.Position) error {
+	// The * of the opening /* can't also be part of the closing */
+	t.next()
+
+	for char := t.next(); char != eof; char = t.next() {
+		if char == '*' && t.peek() == '/' {
+			t.next()
+			return nil
+		}
+	}
+
+	return errorAt(start, "unterminated block comment")
 }
 
-func isKeyword(identifier string) bool {
-	keywords := []string{
-		"class", "function", "void", "return", "do", "let", "var", "int", "while", "field", "constructor", "this", "method", "true", "false", "if", "else", "boolean", "null",
+// scanString reads the rest of a string literal after its opening quote, at
+// open. Strings can't span lines. The value has its escape sequences decoded
+// into Hack characters:
+//
+//	\"  a double quote
+//	\\  a backslash
+//	\n  newline, 128 in the Hack character set
+//	\xNN  the Hack character with the hex code NN
+//
+// Hack has no tab character, so \t is an error rather than being quietly
+// swapped for something else.
+func (t *Tokeniser) scanString(open token.Position) (string, error) {
+	t.text = t.text[:0]
+
+	for char := t.next(); char != eof; char = t.next() {
+		at := t.current
+
+		switch {
+		case char == '"':
+			return string(t.text), nil
+
+		case char == '\n' || char == '\r':
+			return "", errorAt(open, "newline in string literal")
+
+		case char == '\\' && t.peek() == eof:
+			return "", errorAt(open, "unterminated string literal")
+
+		case char == '\\':
+			decoded, err := t.scanEscape(at)
+			if err != nil {
+				return "", err
+			}
+
+			t.text = utf8.AppendRune(t.text, decoded)
+
+		// The extended Hack characters can only be written as escapes
+		case char < ' ' || char > '~':
+			return "", errorAt(at, "unsupported character %q in string", char)
+
+		default:
+			t.text = utf8.AppendRune(t.text, char)
+		}
 	}
 
-	for _, keyword := range keywords {
-		if keyword == identifier {
-			return true
+	return "", errorAt(open, "unterminated string literal")
+}
+
+// scanEscape decodes the escape sequence after a backslash at the given
+// position
+func (t *Tokeniser) scanEscape(at token.Position) (rune, error) {
+	escape := t.next()
+	switch escape {
+	case eof:
+		return 0, errorAt(at, "incomplete escape sequence")
+	case '"', '\\':
+		return escape, nil
+	case 'n':
+		return hackNewLine, nil
+	case 't':
+		return 0, errorAt(at, "\\t is not in the Hack character set, which has no tab")
+	case 'x':
+		digits := ""
+		for len(digits) < 2 && isHexDigit(t.peek()) {
+			digits += string(t.next())
 		}
+
+		code, err := strconv.ParseUint(digits, 16, 8)
+		if err != nil || len(digits) != 2 {
+			return 0, errorAt(at, "\\x must be followed by two hex digits")
+		}
+
+		if !isHackCharacter(rune(code)) {
+			return 0, errorAt(at, "\\x%s is not in the Hack character set", digits)
+		}
+
+		return rune(code), nil
 	}
 
-	return false
+	return 0, errorAt(at, "unknown escape sequence \\%c", escape)
 }
 
-func isSymbol(char rune) bool {
-	symbols := []rune{
-		'{', '}', '(', ')', '[', ']', '.', ',', ';', '+', '-', '*', '/', '&', '|', '<', '>', '=', '~',
+func isHackCharacter(char rune) bool {
+	return (char >= ' ' && char <= '~') || (char >= hackNewLine && char <= hackMaxKey)
+}
+
+func isHexDigit(char rune) bool {
+	return (char >= '0' && char <= '9') || (char >= 'a' && char <= 'f') || (char >= 'A' && char <= 'F')
+}
+
+// positioned stamps a token with the span from start up to the current read
+// position
+func (t *Tokeniser) positioned(tok Token, start token.Position) Token {
+	tok.Start = start
+	tok.End = t.position
+
+	return tok
+}
+
+func isDigit(char rune) bool {
+	return char >= '0' && char <= '9'
+}
+
+func isValidIdentifier(char rune) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+func isIdentifierCharacter(char rune) bool {
+	return isValidIdentifier(char) || isDigit(char) || char == '_'
+}
+
+var keywords = func() map[string]string {
+	keywords := map[string]string{}
+	for _, keyword := range token.Keywords {
+		keywords[keyword] = keyword
 	}
 
-	for _, symbol := range symbols {
-		if symbol == char {
-			return true
-		}
+	return keywords
+}()
+
+// symbols maps each symbol character to its token value, so that symbol
+// tokens don't each need a string of their own
+var symbols = func() map[rune]string {
+	symbols := map[rune]string{}
+	for _, symbol := range "{}()[].,;+-*/&|<>=~" {
+		symbols[symbol] = string(symbol)
 	}
 
-	return false
+	return symbols
+}()
+
+func isSymbol(char rune) bool {
+	_, ok := symbols[char]
+	return ok
 }
 
 func isWhitespace(char rune) bool {
-	return regexp.MustCompile(`\s`).MatchString(string(char))
+	switch char {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+
+	return false
 }
 
 func isSingleLineComment(char rune, nextChar rune) bool {
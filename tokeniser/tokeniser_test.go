@@ -1,19 +1,30 @@
 package tokeniser
 
 import (
+	"errors"
+	"io"
+	"liggi-go-jack-compiler/token"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
+// Positions are covered by their own tests, so the fixtures below only
+// describe the kind and value of each token
+var ignorePositions = cmpopts.IgnoreFields(Token{}, "Start", "End")
+
 func testTokeniser(t *testing.T, input string, expected []Token) {
 	reader := strings.NewReader(input)
 	tokeniser := NewTokeniser(reader)
 	tokens, _ := tokeniser.Tokenise()
 
 	for i, token := range tokens {
-		diff := cmp.Diff(expected[i], token)
+		diff := cmp.Diff(expected[i], token, ignorePositions)
 		if diff != "" {
 			t.Errorf("Token at index %d did not match expected Value:\n%s", i, diff)
 		}
@@ -34,6 +45,43 @@ func TestTokeniser_WhitespaceOnly(t *testing.T) {
 	testTokeniser(t, input, expected)
 }
 
+func TestTokeniser_Positions(t *testing.T) {
+	input := "let x = 10;\n  // comment\n  do \"hi\";"
+	tokeniser := NewTokeniser(strings.NewReader(input), "Main.jack")
+	tokens, err := tokeniser.Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pos := func(line, column, offset int) token.Position {
+		return token.Position{File: "Main.jack", Line: line, Column: column, Offset: offset}
+	}
+
+	expected := []Token{
+		{TokenType: "keyword", Value: "let", Start: pos(1, 1, 0), End: pos(1, 4, 3)},
+		{TokenType: "identifier", Value: "x", Start: pos(1, 5, 4), End: pos(1, 6, 5)},
+		{TokenType: "symbol", Value: "=", Start: pos(1, 7, 6), End: pos(1, 8, 7)},
+		{TokenType: "integerConstant", Value: "10", Start: pos(1, 9, 8), End: pos(1, 11, 10)},
+		{TokenType: "symbol", Value: ";", Start: pos(1, 11, 10), End: pos(1, 12, 11)},
+		{TokenType: "keyword", Value: "do", Start: pos(3, 3, 27), End: pos(3, 5, 29)},
+		{TokenType: "stringConstant", Value: "hi", Start: pos(3, 6, 30), End: pos(3, 10, 34)},
+		{TokenType: "symbol", Value: ";", Start: pos(3, 10, 34), End: pos(3, 11, 35)},
+	}
+
+	if diff := cmp.Diff(expected, tokens); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestTokeniser_Keywords(t *testing.T) {
+	for _, keyword := range token.Keywords {
+		testTokeniser(t, keyword+" "+keyword+"_", []Token{
+			{TokenType: "keyword", Value: keyword},
+			{TokenType: "identifier", Value: keyword + "_"},
+		})
+	}
+}
+
 func TestTokeniser_StringConstants(t *testing.T) {
 	input := "\"Hello, World\" \"String constants are working as expected\""
 	expected := []Token{
@@ -44,6 +92,210 @@ func TestTokeniser_StringConstants(t *testing.T) {
 	testTokeniser(t, input, expected)
 }
 
+func TestTokeniser_StringEscapes(t *testing.T) {
+	input := `"say \"hi\"" "a\\b" "one\ntwo" "\x41\x84"`
+	expected := []Token{
+		{TokenType: "stringConstant", Value: `say "hi"`},
+		{TokenType: "stringConstant", Value: `a\b`},
+		{TokenType: "stringConstant", Value: "one\u0080two"},
+		{TokenType: "stringConstant", Value: "A\u0084"},
+	}
+
+	tokens, err := NewTokeniser(strings.NewReader(input)).Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff := cmp.Diff(expected, tokens, ignorePositions); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+func TestTokeniser_StringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let s = "café";`, `Main.jack:1:13: unsupported character 'é' in string`},
+		{`"tab	here"`, `Main.jack:1:5: unsupported character '\t' in string`},
+		{`"a\tb"`, `Main.jack:1:3: \t is not in the Hack character set, which has no tab`},
+		{`"bad \q"`, `Main.jack:1:6: unknown escape sequence \q`},
+		{`"\x4"`, `Main.jack:1:2: \x must be followed by two hex digits`},
+		{`"\x7f"`, `Main.jack:1:2: \x7f is not in the Hack character set`},
+	}
+
+	for _, test := range tests {
+		_, err := NewTokeniser(strings.NewReader(test.input), "Main.jack").Tokenise()
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("expected error %q for %s, got %v", test.expected, test.input, err)
+		}
+	}
+}
+
+func TestTokeniser_Unterminated(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let s = \"hello;\nlet t = 1;", `Main.jack:1:9: newline in string literal`},
+		{"do f();\nlet s = \"hello", `Main.jack:2:9: unterminated string literal`},
+		{"let s = \"hello\\", `Main.jack:1:9: unterminated string literal`},
+		{"let x = 1;\n  /* never closed\n let y = 2;", `Main.jack:2:3: unterminated block comment`},
+		{"/*/ still a comment", `Main.jack:1:1: unterminated block comment`},
+	}
+
+	for _, test := range tests {
+		_, err := NewTokeniser(strings.NewReader(test.input), "Main.jack").Tokenise()
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("expected error %q, got %v", test.expected, err)
+		}
+	}
+
+	testTokeniser(t, "/**/ x /*/ */ y", []Token{
+		{TokenType: "identifier", Value: "x"},
+		{TokenType: "identifier", Value: "y"},
+	})
+}
+
+func TestTokeniser_ReadError(t *testing.T) {
+	reader := io.MultiReader(strings.NewReader("let x"), iotest.ErrReader(errors.New("disk on fire")))
+
+	_, err := NewTokeniser(reader, "Main.jack").Tokenise()
+	if err == nil || err.Error() != "Main.jack:1:6: disk on fire" {
+		t.Errorf("expected the read error, got %v", err)
+	}
+}
+
+func TestTokeniser_NextAndPeek(t *testing.T) {
+	tokeniser := NewTokeniser(strings.NewReader("let x = 1; $"), "Main.jack")
+
+	third, err := tokeniser.Peek(2)
+	if err != nil || third.Value != "=" {
+		t.Fatalf("expected to peek at =, got %v, %v", third, err)
+	}
+
+	if _, err := tokeniser.Peek(-1); err == nil {
+		t.Errorf("expected an error peeking behind the next token")
+	}
+
+	var values []string
+	for {
+		token, err := tokeniser.Next()
+		if err != nil {
+			if err.Error() != "Main.jack:1:12: unrecognised character: $" {
+				t.Errorf("unexpected error: %v", err)
+			}
+			break
+		}
+
+		values = append(values, token.Value)
+	}
+
+	if diff := cmp.Diff([]string{"let", "x", "=", "1", ";"}, values); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+
+	if _, err := tokeniser.Peek(0); err == nil {
+		t.Errorf("expected the error to be returned again")
+	}
+
+	empty := NewTokeniser(strings.NewReader(" // nothing\n"))
+	if _, err := empty.Peek(0); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if _, err := empty.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestTokeniser_Trivia(t *testing.T) {
+	input := "/** Doc */\nclass Main { // open\n  /* block */ field int x;\n}\n"
+
+	tokens, err := NewTokeniser(strings.NewReader(input)).KeepTrivia().Tokenise()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	whitespace := func(text string) token.Trivia {
+		return token.Trivia{Kind: "whitespace", Text: text}
+	}
+
+	expected := []Token{
+		{TokenType: "keyword", Value: "class", Raw: "class",
+			LeadingTrivia:  []token.Trivia{{Kind: "docComment", Text: "/** Doc */"}, whitespace("\n")},
+			TrailingTrivia: []token.Trivia{whitespace(" ")}},
+		{TokenType: "identifier", Value: "Main", Raw: "Main", TrailingTrivia: []token.Trivia{whitespace(" ")}},
+		{TokenType: "symbol", Value: "{", Raw: "{",
+			TrailingTrivia: []token.Trivia{whitespace(" "), {Kind: "lineComment", Text: "// open"}, whitespace("\n")}},
+		{TokenType: "keyword", Value: "field", Raw: "field",
+			LeadingTrivia:  []token.Trivia{whitespace("  "), {Kind: "blockComment", Text: "/* block */"}, whitespace(" ")},
+			TrailingTrivia: []token.Trivia{whitespace(" ")}},
+		{TokenType: "keyword", Value: "int", Raw: "int", TrailingTrivia: []token.Trivia{whitespace(" ")}},
+		{TokenType: "identifier", Value: "x", Raw: "x"},
+		{TokenType: "symbol", Value: ";", Raw: ";", TrailingTrivia: []token.Trivia{whitespace("\n")}},
+		{TokenType: "symbol", Value: "}", Raw: "}", TrailingTrivia: []token.Trivia{whitespace("\n")}},
+		{TokenType: "endOfFile"},
+	}
+
+	if diff := cmp.Diff(expected, tokens, ignorePositions); diff != "" {
+		t.Errorf("Diff: %v", diff)
+	}
+}
+
+// Keeping trivia should give back the source exactly, without changing the
+// tokens themselves
+func TestTokeniser_TriviaRoundTrip(t *testing.T) {
+	inputs := []string{
+		"",
+		"  \n\t",
+		"let x = 1;",
+		"/**/ let s = \"a\\\"b\\x80\";\r\n\r\n// last line, no newline",
+		"// caf\u00e9 \xff\ndo f(); /* two\n lines */ return;\n\n",
+	}
+
+	paths, err := filepath.Glob("../test-cases/*/*.jack")
+	if err != nil {
+		t.Fatalf("failed to find test-cases: %v", err)
+	}
+
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", path, err)
+		}
+
+		inputs = append(inputs, string(source))
+	}
+
+	ignoreTrivia := cmpopts.IgnoreFields(Token{}, "Raw", "LeadingTrivia", "TrailingTrivia")
+
+	for _, input := range inputs {
+		tokens, err := NewTokeniser(strings.NewReader(input)).KeepTrivia().Tokenise()
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", input, err)
+		}
+
+		var text strings.Builder
+		for _, token := range tokens {
+			text.WriteString(token.FullText())
+		}
+
+		if text.String() != input {
+			t.Errorf("expected %q back, got %q", input, text.String())
+		}
+
+		plain, _ := NewTokeniser(strings.NewReader(input)).Tokenise()
+		if diff := cmp.Diff(plain, tokens[:len(tokens)-1], ignoreTrivia); diff != "" {
+			t.Errorf("tokens for %q changed: %v", input, diff)
+		}
+	}
+
+	_, err = NewTokeniser(strings.NewReader("do f();\n/* open")).KeepTrivia().Tokenise()
+	if err == nil || err.Error() != "2:1: unterminated block comment" {
+		t.Errorf("expected an unterminated comment error, got %v", err)
+	}
+}
+
 func TestTokeniser_IntegerConstants(t *testing.T) {
 	input := "let numbers = 123 + 456 + 789;"
 	expected := []Token{
@@ -975,3 +1227,35 @@ func TestTokeniser_Square(t *testing.T) {
 
 	testTokeniser(t, input, expected)
 }
+
+// BenchmarkTokeniser measures throughput over every Jack file in test-cases
+func BenchmarkTokeniser(b *testing.B) {
+	paths, err := filepath.Glob("../test-cases/*/*.jack")
+	if err != nil || len(paths) == 0 {
+		b.Fatalf("failed to find test-cases: %v", err)
+	}
+
+	var sources []string
+	size := 0
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			b.Fatalf("failed to read %s: %v", path, err)
+		}
+
+		sources = append(sources, string(source))
+		size += len(source)
+	}
+
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for _, source := range sources {
+			if _, err := NewTokeniser(strings.NewReader(source)).Tokenise(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package vminterpreter
+
+import (
+	"errors"
+	"fmt"
+	"liggi-go-jack-compiler/vm"
+)
+
+const (
+	SP   = 0
+	LCL  = 1
+	ARG  = 2
+	THIS = 3
+	THAT = 4
+
+	stackBase  = 256
+	stackLimit = 2048
+	staticBase = 16
+	staticEnd  = 256
+)
+
+// ErrHalt can be returned by a native function to stop the program cleanly
+var ErrHalt = errors.New("halt")
+
+// Native is a function implemented in Go rather than VM code, such as part of
+// the OS. It receives the arguments it was called with and returns the value
+// to push in their place, which should be 0 for void functions.
+type Native func(interpreter *Interpreter, args []int16) (int16, error)
+
+// File is a vm.File
+type File = vm.File
+
+// Interpreter runs VM code on a model of the Hack platform: a 32K word RAM
+// holding the stack, the heap, statics and the memory mapped screen and
+// keyboard, with all arithmetic done in 16-bit two's complement.
+type Interpreter struct {
+	RAM [32768]int16
+
+	// MaxSteps stops a program that runs for too long. 0 means no limit.
+	MaxSteps int
+	Steps    int
+
+	files     []File
+	natives   map[string]Native
+	program   []instruction
+	functions map[string]int
+	pc        int
+}
+
+type instruction struct {
+	vm.Command
+
+	// Worked out when the program is linked
+	target int
+}
+
+func NewInterpreter(files ...File) *Interpreter {
+	return &Interpreter{
+		files:   files,
+		natives: map[string]Native{},
+	}
+}
+
+// Register provides a native implementation of a function. A function defined
+// in VM code takes precedence over a native one with the same name.
+func (in *Interpreter) Register(name string, native Native) {
+	in.natives[name] = native
+}
+
+// Run links the program and runs it from Sys.init, or Main.main if the
+// program has no Sys.init, until the entry function returns or the program
+// halts. The entry function's return value is left at the bottom of the
+// stack, RAM[256].
+func (in *Interpreter) Run() error {
+	if err := in.link(); err != nil {
+		return err
+	}
+
+	entry := "Sys.init"
+	if _, ok := in.functions[entry]; !ok {
+		entry = "Main.main"
+	}
+
+	if _, ok := in.functions[entry]; !ok {
+		return fmt.Errorf("program has no Sys.init or Main.main function")
+	}
+
+	in.RAM[SP] = stackBase
+	in.Steps = 0
+
+	// A return address of -1 marks the bottom frame, so returning from the
+	// entry function ends the program
+	if err := in.pushFrame(-1, 0); err != nil {
+		return err
+	}
+	in.pc = in.functions[entry]
+
+	for in.pc >= 0 {
+		if in.MaxSteps > 0 && in.Steps >= in.MaxSteps {
+			return fmt.Errorf("program did not finish within %d steps", in.MaxSteps)
+		}
+		in.Steps++
+
+		err := in.step()
+		if errors.Is(err, ErrHalt) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// link parses every file, and resolves labels, functions and statics to
+// addresses
+func (in *Interpreter) link() error {
+	in.program = nil
+	in.functions = map[string]int{}
+
+	for _, file := range in.files {
+		commands, err := vm.Parse(file)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range commands {
+			in.program = append(in.program, instruction{Command: c})
+		}
+	}
+
+	// Return addresses are stored on the stack, so must fit in a word
+	if len(in.program) > 32767 {
+		return fmt.Errorf("program has %d instructions, more than the 32767 that can be run", len(in.program))
+	}
+
+	labels := map[string]int{}
+	statics := map[string]int{}
+	function := ""
+
+	for i := range in.program {
+		instruction := &in.program[i]
+
+		switch instruction.Op {
+		case "function":
+			function = instruction.Name
+			if _, ok := in.functions[function]; ok {
+				return instruction.Errorf("function %s is defined more than once", function)
+			}
+			in.functions[function] = i
+
+		case "label":
+			label := function + "$" + instruction.Name
+			if _, ok := labels[label]; ok {
+				return instruction.Errorf("label %s is defined more than once in %s", instruction.Name, function)
+			}
+			labels[label] = i
+
+		case "goto", "if-goto":
+			// Labels are resolved below, once they've all been seen
+			instruction.Name = function + "$" + instruction.Name
+
+		case "push", "pop":
+			if instruction.Segment != "static" {
+				continue
+			}
+
+			static := fmt.Sprintf("%s.%d", instruction.File, instruction.Index)
+			if _, ok := statics[static]; !ok {
+				if staticBase+len(statics) >= staticEnd {
+					return instruction.Errorf("too many static variables")
+				}
+				statics[static] = staticBase + len(statics)
+			}
+			instruction.target = statics[static]
+		}
+	}
+
+	for i := range in.program {
+		instruction := &in.program[i]
+
+		switch instruction.Op {
+		case "goto", "if-goto":
+			target, ok := labels[instruction.Name]
+			if !ok {
+				return instruction.Errorf("undefined label %s", instruction.Args[0])
+			}
+			instruction.target = target
+
+		case "call":
+			target, ok := in.functions[instruction.Name]
+			if !ok {
+				if _, ok := in.natives[instruction.Name]; !ok {
+					return instruction.Errorf("call to undefined function %s", instruction.Name)
+				}
+
+				// Native calls are marked with a target of -1
+				target = -1
+			}
+			instruction.target = target
+		}
+	}
+
+	return nil
+}
+
+func (in *Interpreter) step() error {
+	instruction := &in.program[in.pc]
+	in.pc++
+
+	switch instruction.Op {
+	case "add", "sub", "and", "or", "eq", "gt", "lt":
+		y, err := in.pop(instruction)
+		if err != nil {
+			return err
+		}
+		x, err := in.pop(instruction)
+		if err != nil {
+			return err
+		}
+
+		return in.push(instruction, binary(instruction.Op, x, y))
+
+	case "neg", "not":
+		x, err := in.pop(instruction)
+		if err != nil {
+			return err
+		}
+
+		if instruction.Op == "neg" {
+			return in.push(instruction, -x)
+		}
+		return in.push(instruction, ^x)
+
+	case "push":
+		var value int16
+		if instruction.Segment == "constant" {
+			value = int16(instruction.Index)
+		} else {
+			address, err := in.address(instruction)
+			if err != nil {
+				return err
+			}
+			value = in.RAM[address]
+		}
+
+		return in.push(instruction, value)
+
+	case "pop":
+		address, err := in.address(instruction)
+		if err != nil {
+			return err
+		}
+
+		value, err := in.pop(instruction)
+		if err != nil {
+			return err
+		}
+
+		in.RAM[address] = value
+
+	case "label", "function":
+		if instruction.Op == "function" {
+			for i := 0; i < instruction.Index; i++ {
+				if err := in.push(instruction, 0); err != nil {
+					return err
+				}
+			}
+		}
+
+	case "goto":
+		in.pc = instruction.target
+
+	case "if-goto":
+		condition, err := in.pop(instruction)
+		if err != nil {
+			return err
+		}
+
+		if condition != 0 {
+			in.pc = instruction.target
+		}
+
+	case "call":
+		if instruction.target < 0 {
+			return in.callNative(instruction)
+		}
+
+		if err := in.pushFrame(in.pc, instruction.Index); err != nil {
+			return instruction.Errorf("%v", err)
+		}
+		in.pc = instruction.target
+
+	case "return":
+		return in.popFrame(instruction)
+	}
+
+	return nil
+}
+
+func binary(op string, x, y int16) int16 {
+	switch op {
+	case "add":
+		return x + y
+	case "sub":
+		return x - y
+	case "and":
+		return x & y
+	case "or":
+		return x | y
+	case "eq":
+		return boolean(x == y)
+	case "gt":
+		return boolean(x > y)
+	case "lt":
+		return boolean(x < y)
+	}
+
+	return 0
+}
+
+// boolean converts to the VM's true (-1) and false (0)
+func boolean(b bool) int16 {
+	if b {
+		return -1
+	}
+
+	return 0
+}
+
+func (in *Interpreter) push(instruction *instruction, value int16) error {
+	sp := in.RAM[SP]
+	if sp < stackBase || sp >= stackLimit {
+		return instruction.Errorf("stack overflow")
+	}
+
+	in.RAM[sp] = value
+	in.RAM[SP] = sp + 1
+	return nil
+}
+
+// pop takes the top value off the stack, which mustn't reach down into the
+// saved frame below the current function's locals
+func (in *Interpreter) pop(instruction *instruction) (int16, error) {
+	sp := in.RAM[SP] - 1
+	if sp < stackBase || sp < in.RAM[LCL] || sp >= stackLimit {
+		return 0, instruction.Errorf("stack underflow")
+	}
+
+	in.RAM[SP] = sp
+	return in.RAM[sp], nil
+}
+
+// address works out the RAM address of a segment entry
+func (in *Interpreter) address(instruction *instruction) (int, error) {
+	var base int
+
+	switch instruction.Segment {
+	case "local":
+		base = int(in.RAM[LCL])
+	case "argument":
+		base = int(in.RAM[ARG])
+	case "this":
+		base = int(in.RAM[THIS])
+	case "that":
+		base = int(in.RAM[THAT])
+	case "pointer":
+		return THIS + instruction.Index, nil
+	case "temp":
+		return 5 + instruction.Index, nil
+	case "static":
+		return instruction.target, nil
+	}
+
+	address := base + instruction.Index
+	if address < 0 || address >= len(in.RAM) {
+		return 0, instruction.Errorf("%s %d is outside of memory, at address %d", instruction.Segment, instruction.Index, address)
+	}
+
+	return address, nil
+}
+
+// pushFrame saves the caller's frame and sets up ARG and LCL for a function
+// that's been passed args arguments
+func (in *Interpreter) pushFrame(returnAddress, args int) error {
+	sp := int(in.RAM[SP])
+	if sp+5 >= stackLimit {
+		return fmt.Errorf("stack overflow")
+	}
+
+	in.RAM[sp] = int16(returnAddress)
+	copy(in.RAM[sp+1:sp+5], in.RAM[LCL:THAT+1])
+
+	in.RAM[ARG] = int16(sp - args)
+	in.RAM[LCL] = int16(sp + 5)
+	in.RAM[SP] = int16(sp + 5)
+
+	return nil
+}
+
+func (in *Interpreter) popFrame(instruction *instruction) error {
+	frame := int(in.RAM[LCL])
+	if frame < stackBase+5 || frame >= stackLimit {
+		return instruction.Errorf("return without a valid frame")
+	}
+
+	returnAddress := int(in.RAM[frame-5])
+
+	value, err := in.pop(instruction)
+	if err != nil {
+		return err
+	}
+
+	arg := int(in.RAM[ARG])
+	if arg < stackBase || arg >= stackLimit {
+		return instruction.Errorf("return without a valid frame")
+	}
+
+	in.RAM[arg] = value
+	in.RAM[SP] = int16(arg + 1)
+	copy(in.RAM[LCL:THAT+1], in.RAM[frame-4:frame])
+
+	in.pc = returnAddress
+	return nil
+}
+
+func (in *Interpreter) callNative(instruction *instruction) error {
+	sp := int(in.RAM[SP])
+	n := instruction.Index
+	if sp-n < stackBase || sp-n < int(in.RAM[LCL]) {
+		return instruction.Errorf("stack underflow")
+	}
+
+	args := make([]int16, n)
+	copy(args, in.RAM[sp-n:sp])
+	in.RAM[SP] = int16(sp - n)
+
+	result, err := in.natives[instruction.Name](in, args)
+	if errors.Is(err, ErrHalt) {
+		return err
+	}
+	if err != nil {
+		return instruction.Errorf("%s: %v", instruction.Name, err)
+	}
+
+	return in.push(instruction, result)
+}
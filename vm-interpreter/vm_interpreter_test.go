@@ -0,0 +1,187 @@
+package vminterpreter
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestInterpreter_Program(t *testing.T) {
+	main := File{Name: "Main", Code: `
+function Main.main 1
+push constant 5
+call Main.factorial 1
+pop local 0
+push constant 3000
+pop pointer 1
+push local 0
+pop that 2
+push constant 7
+pop static 0
+push constant 9
+call Other.set 1
+pop temp 0
+push static 0
+push that 2
+add
+call Other.get 0
+add
+return
+
+// Multiplies through repeated addition, so there's no need for Math
+function Main.factorial 2
+push argument 0
+push constant 1
+gt
+if-goto RECURSE
+push constant 1
+return
+label RECURSE
+push argument 0
+push constant 1
+sub
+call Main.factorial 1
+pop local 0
+label LOOP
+push argument 0
+push constant 0
+eq
+if-goto DONE
+push local 1
+push local 0
+add
+pop local 1
+push argument 0
+push constant 1
+sub
+pop argument 0
+goto LOOP
+label DONE
+push local 1
+return
+`}
+
+	other := File{Name: "Other", Code: `
+function Other.set 0
+push argument 0
+pop static 0
+push constant 0
+return
+function Other.get 0
+push static 0
+return
+`}
+
+	interpreter := NewInterpreter(main, other)
+	if err := interpreter.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 7 from Main's static, 120 from the array, 9 from Other's static
+	if interpreter.RAM[stackBase] != 136 {
+		t.Errorf("expected 136 at the bottom of the stack, got %d", interpreter.RAM[stackBase])
+	}
+
+	if interpreter.RAM[3002] != 120 {
+		t.Errorf("expected 120 at RAM[3002], got %d", interpreter.RAM[3002])
+	}
+
+	if interpreter.RAM[SP] != stackBase+1 {
+		t.Errorf("expected only the return value left on the stack, got SP %d", interpreter.RAM[SP])
+	}
+}
+
+func TestInterpreter_Arithmetic(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected int16
+	}{
+		{"push constant 32767\npush constant 1\nadd", -32768},
+		{"push constant 0\npush constant 32767\nsub\npush constant 2\nsub", 32767},
+		{"push constant 0\nneg", 0},
+		{"push constant 0\nnot", -1},
+		{"push constant 12\npush constant 10\nand", 8},
+		{"push constant 12\npush constant 10\nor", 14},
+		// Comparisons aren't fooled by a difference too big for a word
+		{"push constant 32000\nneg\npush constant 32000\nlt", -1},
+		{"push constant 32000\nneg\npush constant 32000\ngt", 0},
+		{"push constant 5\npush constant 5\neq", -1},
+	}
+
+	for _, test := range tests {
+		interpreter := NewInterpreter(File{Name: "Main", Code: "function Main.main 0\n" + test.code + "\nreturn"})
+		if err := interpreter.Run(); err != nil {
+			t.Fatalf("unexpected error for %q: %v", test.code, err)
+		}
+
+		if result := interpreter.RAM[stackBase]; result != test.expected {
+			t.Errorf("expected %q to give %d, got %d", test.code, test.expected, result)
+		}
+	}
+}
+
+func TestInterpreter_Natives(t *testing.T) {
+	code := `function Sys.init 0
+push constant 6
+push constant 7
+call Math.multiply 2
+pop static 0
+call Sys.halt 0
+push constant 1
+pop static 0
+return`
+
+	interpreter := NewInterpreter(File{Name: "Sys", Code: code})
+	interpreter.Register("Math.multiply", func(in *Interpreter, args []int16) (int16, error) {
+		return args[0] * args[1], nil
+	})
+	interpreter.Register("Sys.halt", func(in *Interpreter, args []int16) (int16, error) {
+		return 0, ErrHalt
+	})
+
+	if err := interpreter.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if interpreter.RAM[staticBase] != 42 {
+		t.Errorf("expected 42 in the static, got %d", interpreter.RAM[staticBase])
+	}
+}
+
+func TestInterpreter_Errors(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"function Main.main 0\ncall Missing.f 0\nreturn", "Main.vm:2: call to undefined function Missing.f"},
+		{"function Main.main 0\ngoto NOWHERE\nreturn", "Main.vm:2: undefined label NOWHERE"},
+		{"function Main.main 0\npush nowhere 1\nreturn", "Main.vm:2: unknown segment nowhere"},
+		{"function Main.main 0\npop constant 1\nreturn", "Main.vm:2: cannot pop to the constant segment"},
+		{"function Main.main 0\nadd\nreturn", "Main.vm:2: stack underflow"},
+		{"function Main.main 0\ncall Main.main 0\nreturn", "Main.vm:2: stack overflow"},
+		{"function Main.main 0\npush constant 32767\npop pointer 0\npush this 1\nreturn", "Main.vm:4: this 1 is outside of memory, at address 32768"},
+		{"function Main.main 0\ncall Main.fail 0\nreturn\nfunction Main.fail 0\ncall Sys.error 0\nreturn", "Main.vm:5: Sys.error: failed"},
+		{"function Main.other 0\nreturn", "program has no Sys.init or Main.main function"},
+	}
+
+	for _, test := range tests {
+		interpreter := NewInterpreter(File{Name: "Main", Code: test.code})
+		interpreter.Register("Sys.error", func(in *Interpreter, args []int16) (int16, error) {
+			return 0, errors.New("failed")
+		})
+
+		err := interpreter.Run()
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("expected error %q, got %v", test.expected, err)
+		}
+	}
+}
+
+func TestInterpreter_MaxSteps(t *testing.T) {
+	interpreter := NewInterpreter(File{Name: "Main", Code: "function Main.main 0\nlabel LOOP\ngoto LOOP"})
+	interpreter.MaxSteps = 1000
+
+	err := interpreter.Run()
+	if err == nil || err.Error() != "program did not finish within 1000 steps" {
+		t.Errorf("expected the step limit to be hit, got %v", err)
+	}
+}
@@ -0,0 +1,28 @@
+package vmtranslator
+
+// SourceMap links each line of the assembly back to the VM command it was
+// translated from. The bootstrap code comes from no command, so isn't mapped.
+type SourceMap struct {
+	Mappings []Mapping `json:"mappings"`
+}
+
+// Mapping gives the VM command of one line of assembly. Both lines are
+// 0-based, like the VM lines in the code generator's source maps, so the two
+// can be joined to get from assembly to Jack. File is the VM file's Name.
+type Mapping struct {
+	AsmLine int    `json:"asmLine"`
+	File    string `json:"file"`
+	VMLine  int    `json:"vmLine"`
+}
+
+// mapLines maps the assembly written since line start to the command c
+func (t *VMTranslator) mapLines(start int, c command) {
+	for line := start; line < t.lines; line++ {
+		t.sourceMap.Mappings = append(t.sourceMap.Mappings, Mapping{AsmLine: line, File: c.File, VMLine: c.Line - 1})
+	}
+}
+
+// SourceMap returns the source map of the assembly last translated
+func (t *VMTranslator) SourceMap() SourceMap {
+	return t.sourceMap
+}
@@ -0,0 +1,315 @@
+package vmtranslator
+
+import (
+	"fmt"
+	"liggi-go-jack-compiler/vm"
+	"strings"
+)
+
+// File is a vm.File
+type File = vm.File
+
+type VMTranslator struct {
+	files []File
+
+	function   string
+	labelCount int
+	output     strings.Builder
+	lines      int
+	sourceMap  SourceMap
+}
+
+type command = vm.Command
+
+// osClasses are the classes of the Jack OS. Unlike the interpreter, which has
+// them built in, a translated program only has them if their .vm files are
+// linked in with it.
+var osClasses = map[string]bool{
+	"Array": true, "Keyboard": true, "Math": true, "Memory": true,
+	"Output": true, "Screen": true, "String": true, "Sys": true,
+}
+
+var segmentPointers = map[string]string{
+	"local":    "LCL",
+	"argument": "ARG",
+	"this":     "THIS",
+	"that":     "THAT",
+}
+
+func NewVMTranslator(files ...File) *VMTranslator {
+	return &VMTranslator{
+		files: files,
+	}
+}
+
+// Translate links every file into a single Hack assembly program. The program
+// starts with the bootstrap code, which sets up the stack and calls Sys.init,
+// or Main.main if the OS isn't part of the program.
+func (t *VMTranslator) Translate() (string, error) {
+	var commands []command
+	functions := map[string]bool{}
+
+	for _, file := range t.files {
+		parsed, err := vm.Parse(file)
+		if err != nil {
+			return "", err
+		}
+
+		for _, c := range parsed {
+			if c.Op == "function" {
+				if functions[c.Name] {
+					return "", c.Errorf("function %s is defined more than once", c.Name)
+				}
+				functions[c.Name] = true
+			}
+		}
+
+		commands = append(commands, parsed...)
+	}
+
+	for _, c := range commands {
+		if c.Op == "call" && !functions[c.Name] {
+			if class, _, _ := strings.Cut(c.Name, "."); osClasses[class] {
+				return "", c.Errorf("call to undefined function %s, which is part of the Jack OS: copy the OS .vm files into the program's directory", c.Name)
+			}
+
+			return "", c.Errorf("call to undefined function %s", c.Name)
+		}
+	}
+
+	entry := "Sys.init"
+	if !functions[entry] {
+		entry = "Main.main"
+	}
+
+	if !functions[entry] {
+		return "", fmt.Errorf("program has no Sys.init or Main.main function")
+	}
+
+	t.output.Reset()
+	t.labelCount = 0
+	t.lines = 0
+	t.sourceMap = SourceMap{}
+	t.writeBootstrap(entry)
+
+	for _, c := range commands {
+		start := t.lines
+		t.writeCommand(c)
+		t.mapLines(start, c)
+	}
+
+	return t.output.String(), nil
+}
+
+func (t *VMTranslator) write(lines ...string) {
+	for _, line := range lines {
+		t.output.WriteString(line)
+		t.output.WriteString("\n")
+	}
+
+	t.lines += len(lines)
+}
+
+func (t *VMTranslator) uniqueLabel(prefix string) string {
+	t.labelCount++
+	return fmt.Sprintf("%s.%d", prefix, t.labelCount)
+}
+
+func (t *VMTranslator) writeBootstrap(entry string) {
+	t.write("// bootstrap", "@256", "D=A", "@SP", "M=D")
+	t.writeCall(entry, 0)
+
+	// If the entry point ever returns, stop here rather than running off
+	// into whatever follows
+	halt := t.uniqueLabel("HALT")
+	t.write("("+halt+")", "@"+halt, "0;JMP")
+}
+
+func (t *VMTranslator) writeCommand(c command) {
+	t.write("// " + c.String())
+
+	switch c.Op {
+	case "add":
+		t.writeBinary("M=D+M")
+	case "sub":
+		t.writeBinary("M=M-D")
+	case "and":
+		t.writeBinary("M=D&M")
+	case "or":
+		t.writeBinary("M=D|M")
+	case "neg":
+		t.write("@SP", "A=M-1", "M=-M")
+	case "not":
+		t.write("@SP", "A=M-1", "M=!M")
+	case "eq":
+		t.writeComparison("JEQ")
+	case "gt":
+		t.writeComparison("JGT")
+	case "lt":
+		t.writeComparison("JLT")
+	case "push":
+		t.writePush(c)
+	case "pop":
+		t.writePop(c)
+	case "label":
+		t.write("(" + t.scopedLabel(c.Name) + ")")
+	case "goto":
+		t.write("@"+t.scopedLabel(c.Name), "0;JMP")
+	case "if-goto":
+		t.write("@SP", "AM=M-1", "D=M", "@"+t.scopedLabel(c.Name), "D;JNE")
+	case "function":
+		t.writeFunction(c.Name, c.Index)
+	case "call":
+		t.writeCall(c.Name, c.Index)
+	case "return":
+		t.writeReturn()
+	}
+}
+
+// scopedLabel gives a label a name unique to the function it's declared in
+func (t *VMTranslator) scopedLabel(label string) string {
+	return t.function + "$" + label
+}
+
+func (t *VMTranslator) writeBinary(operation string) {
+	t.write("@SP", "AM=M-1", "D=M", "A=A-1", operation)
+}
+
+// writeComparison leaves -1 (true) or 0 (false) on the stack
+func (t *VMTranslator) writeComparison(jump string) {
+	isTrue := t.uniqueLabel("TRUE")
+	end := t.uniqueLabel("END")
+
+	if jump == "JEQ" {
+		// x - y is 0 only when x = y, even if the subtraction overflows
+		t.write("@SP", "AM=M-1", "D=M", "A=A-1", "D=M-D")
+	} else {
+		t.writeOrder()
+	}
+
+	t.write(
+		"@"+isTrue, "D;"+jump,
+		"@SP", "A=M-1", "M=0",
+		"@"+end, "0;JMP",
+		"("+isTrue+")",
+		"@SP", "A=M-1", "M=-1",
+		"("+end+")",
+	)
+}
+
+// writeOrder pops y and leaves D with the sign of x - y, for x at the new top
+// of the stack. x - y overflows when x and y are far apart, which can only
+// happen when their signs differ, so then the order is decided by the signs
+// alone, and x - y is only worked out when they match. y is parked in R13.
+func (t *VMTranslator) writeOrder() {
+	xNegative := t.uniqueLabel("XNEG")
+	subtract := t.uniqueLabel("SUB")
+	done := t.uniqueLabel("ORDER")
+
+	t.write(
+		"@SP", "AM=M-1", "D=M", "@R13", "M=D",
+		"@SP", "A=M-1", "D=M",
+		"@"+xNegative, "D;JLT",
+		// x >= 0, so if y < 0 then x > y
+		"@R13", "D=M",
+		"@"+subtract, "D;JGE",
+		"D=1",
+		"@"+done, "0;JMP",
+		"("+xNegative+")",
+		// x < 0, so if y >= 0 then x < y
+		"@R13", "D=M",
+		"@"+subtract, "D;JLT",
+		"D=-1",
+		"@"+done, "0;JMP",
+		"("+subtract+")",
+		"@R13", "D=M",
+		"@SP", "A=M-1", "D=M-D",
+		"("+done+")",
+	)
+}
+
+// address returns the instructions that point A at a segment entry
+func (t *VMTranslator) address(c command) []string {
+	switch c.Segment {
+	case "local", "argument", "this", "that":
+		return []string{fmt.Sprintf("@%d", c.Index), "D=A", "@" + segmentPointers[c.Segment], "A=D+M"}
+	case "pointer":
+		return []string{fmt.Sprintf("@R%d", 3+c.Index)}
+	case "temp":
+		return []string{fmt.Sprintf("@R%d", 5+c.Index)}
+	}
+
+	return []string{fmt.Sprintf("@%s.%d", c.File, c.Index)}
+}
+
+func (t *VMTranslator) writePush(c command) {
+	if c.Segment == "constant" {
+		t.write(fmt.Sprintf("@%d", c.Index), "D=A")
+	} else {
+		t.write(t.address(c)...)
+		t.write("D=M")
+	}
+
+	t.writePushD()
+}
+
+func (t *VMTranslator) writePop(c command) {
+	// Work the address out first and park it in R13, since D is needed for
+	// the value being popped
+	t.write(t.address(c)...)
+	t.write("D=A", "@R13", "M=D")
+	t.write("@SP", "AM=M-1", "D=M", "@R13", "A=M", "M=D")
+}
+
+func (t *VMTranslator) writePushD() {
+	t.write("@SP", "A=M", "M=D", "@SP", "M=M+1")
+}
+
+func (t *VMTranslator) writeFunction(name string, locals int) {
+	t.function = name
+
+	t.write("(" + name + ")")
+	for i := 0; i < locals; i++ {
+		t.write("@SP", "A=M", "M=0", "@SP", "M=M+1")
+	}
+}
+
+// writeCall saves the caller's frame, repositions ARG and LCL for the callee,
+// then jumps to it
+func (t *VMTranslator) writeCall(name string, args int) {
+	returnAddress := t.uniqueLabel(name + "$ret")
+
+	t.write("@"+returnAddress, "D=A")
+	t.writePushD()
+
+	for _, pointer := range []string{"LCL", "ARG", "THIS", "THAT"} {
+		t.write("@"+pointer, "D=M")
+		t.writePushD()
+	}
+
+	t.write(
+		"@SP", "D=M", fmt.Sprintf("@%d", args+5), "D=D-A", "@ARG", "M=D",
+		"@SP", "D=M", "@LCL", "M=D",
+		"@"+name, "0;JMP",
+		"("+returnAddress+")",
+	)
+}
+
+// writeReturn puts the return value where the caller expects it, restores
+// the caller's frame and jumps back
+func (t *VMTranslator) writeReturn() {
+	t.write(
+		// R13 = frame, R14 = return address
+		"@LCL", "D=M", "@R13", "M=D",
+		"@5", "A=D-A", "D=M", "@R14", "M=D",
+		// *ARG = pop(), SP = ARG + 1
+		"@SP", "AM=M-1", "D=M", "@ARG", "A=M", "M=D",
+		"@ARG", "D=M+1", "@SP", "M=D",
+	)
+
+	for _, pointer := range []string{"THAT", "THIS", "ARG", "LCL"} {
+		t.write("@R13", "AM=M-1", "D=M", "@"+pointer, "M=D")
+	}
+
+	t.write("@R14", "A=M", "0;JMP")
+}
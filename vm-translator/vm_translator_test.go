@@ -0,0 +1,75 @@
+package vmtranslator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranslate_Errors(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"function Main.main 0\ncall Missing.f 0\nreturn", "Main.vm:2: call to undefined function Missing.f"},
+		{"function Main.main 0\ncall String.new 0\nreturn", "Main.vm:2: call to undefined function String.new, which is part of the Jack OS: copy the OS .vm files into the program's directory"},
+		{"function Main.main 0\npush nowhere 1\nreturn", "Main.vm:2: unknown segment nowhere"},
+		{"function Main.main 0\npop constant 1\nreturn", "Main.vm:2: cannot pop to the constant segment"},
+		{"function Main.main 0\nfrobnicate\nreturn", "Main.vm:2: unknown command frobnicate"},
+		{"function Main.main 0\npush temp 8\nreturn", "Main.vm:2: temp index 8 out of range"},
+		{"function Main.other 0\nreturn", "program has no Sys.init or Main.main function"},
+	}
+
+	for _, test := range tests {
+		_, err := NewVMTranslator(File{Name: "Main", Code: test.code}).Translate()
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("expected error %q, got %v", test.expected, err)
+		}
+	}
+}
+
+func TestTranslate_SourceMap(t *testing.T) {
+	files := []File{
+		{Name: "Main", Code: "function Main.main 0\n// a comment\npush constant 1\ncall Helper.f 1\nreturn\n"},
+		{Name: "Helper", Code: "function Helper.f 0\n\npush argument 0\nreturn\n"},
+	}
+
+	translator := NewVMTranslator(files...)
+	asm, err := translator.Translate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	asmLines := strings.Split(strings.TrimSuffix(asm, "\n"), "\n")
+	vmLines := map[string][]string{}
+	for _, file := range files {
+		vmLines[file.Name] = strings.Split(file.Code, "\n")
+	}
+
+	mappings := translator.SourceMap().Mappings
+	if len(mappings) == 0 || mappings[len(mappings)-1].AsmLine != len(asmLines)-1 {
+		t.Fatalf("expected every line up to the last to be mapped, got %v", mappings)
+	}
+
+	// Each command's code starts with a comment naming it, which should be
+	// the VM line it's mapped to
+	commands := 0
+	for i, mapping := range mappings {
+		if i > 0 && mapping.AsmLine != mappings[i-1].AsmLine+1 {
+			t.Errorf("expected the lines after the bootstrap to be mapped in order, got %v after %v", mapping, mappings[i-1])
+		}
+
+		line := asmLines[mapping.AsmLine]
+		if !strings.HasPrefix(line, "// ") {
+			continue
+		}
+
+		commands++
+		if expected := "// " + vmLines[mapping.File][mapping.VMLine]; line != expected {
+			t.Errorf("expected asm line %d, %q, to map to %q", mapping.AsmLine, line, expected)
+		}
+	}
+
+	if commands != 7 {
+		t.Errorf("expected 7 commands to be mapped, got %d", commands)
+	}
+}
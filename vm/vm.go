@@ -0,0 +1,136 @@
+// Package vm reads VM code, checking each command is well formed, for the
+// translator and the interpreter to share
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// File is a single .vm file. Name is the file's base name, which scopes its
+// static variables.
+type File struct {
+	Name string
+	Code string
+}
+
+// Command is one line of VM code. Its arguments are decoded by Parse: Name is
+// the first argument, of a label, jump, function or call, or the segment of a
+// push or pop, and Index the second.
+type Command struct {
+	File string
+	Line int
+	Op   string
+	Args []string
+
+	Name    string
+	Segment string
+	Index   int
+}
+
+// Parse reads the commands in a file, skipping comments and blank lines
+func Parse(file File) ([]Command, error) {
+	var commands []Command
+
+	scanner := bufio.NewScanner(strings.NewReader(file.Code))
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := scanner.Text()
+		if comment := strings.Index(text, "//"); comment >= 0 {
+			text = text[:comment]
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		c := Command{File: file.Name, Line: line, Op: fields[0], Args: fields[1:]}
+		if err := c.parseArgs(); err != nil {
+			return nil, err
+		}
+
+		commands = append(commands, c)
+	}
+
+	return commands, scanner.Err()
+}
+
+// Errorf returns an error positioned at the command, like Main.vm:12: message
+func (c Command) Errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s.vm:%d: %s", c.File, c.Line, fmt.Sprintf(format, args...))
+}
+
+// String returns the command as it would be written
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Op}, c.Args...), " ")
+}
+
+// parseArgs checks a command has the right arguments, and decodes them
+func (c *Command) parseArgs() error {
+	expected := 0
+
+	switch c.Op {
+	case "add", "sub", "neg", "eq", "gt", "lt", "and", "or", "not", "return":
+	case "label", "goto", "if-goto":
+		expected = 1
+	case "push", "pop", "function", "call":
+		expected = 2
+	default:
+		return c.Errorf("unknown command %s", c.Op)
+	}
+
+	if len(c.Args) != expected {
+		arguments := "arguments"
+		if expected == 1 {
+			arguments = "argument"
+		}
+
+		return c.Errorf("%s expects %d %s, got %d", c.Op, expected, arguments, len(c.Args))
+	}
+
+	if expected == 0 {
+		return nil
+	}
+
+	c.Name = c.Args[0]
+	if expected == 1 {
+		return nil
+	}
+
+	n, err := strconv.Atoi(c.Args[1])
+	if err != nil || n < 0 || n > 32767 {
+		return c.Errorf("invalid number %s", c.Args[1])
+	}
+	c.Index = n
+
+	if c.Op != "push" && c.Op != "pop" {
+		return nil
+	}
+
+	c.Segment = c.Name
+	switch c.Segment {
+	case "constant":
+		if c.Op == "pop" {
+			return c.Errorf("cannot pop to the constant segment")
+		}
+	case "pointer":
+		if n > 1 {
+			return c.Errorf("pointer index %d out of range", n)
+		}
+	case "temp":
+		if n > 7 {
+			return c.Errorf("temp index %d out of range", n)
+		}
+	case "local", "argument", "this", "that", "static":
+	default:
+		return c.Errorf("unknown segment %s", c.Segment)
+	}
+
+	return nil
+}
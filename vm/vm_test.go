@@ -0,0 +1,62 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	code := `// Main.vm
+function Main.main 1
+
+push constant 7 // seven
+pop local 0
+label LOOP
+call Math.abs 1
+add
+`
+
+	expected := []Command{
+		{File: "Main", Line: 2, Op: "function", Args: []string{"Main.main", "1"}, Name: "Main.main", Index: 1},
+		{File: "Main", Line: 4, Op: "push", Args: []string{"constant", "7"}, Name: "constant", Segment: "constant", Index: 7},
+		{File: "Main", Line: 5, Op: "pop", Args: []string{"local", "0"}, Name: "local", Segment: "local"},
+		{File: "Main", Line: 6, Op: "label", Args: []string{"LOOP"}, Name: "LOOP"},
+		{File: "Main", Line: 7, Op: "call", Args: []string{"Math.abs", "1"}, Name: "Math.abs", Index: 1},
+		{File: "Main", Line: 8, Op: "add", Args: []string{}},
+	}
+
+	commands, err := Parse(File{Name: "Main", Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(expected, commands); diff != "" {
+		t.Errorf("unexpected commands (-want +got):\n%s", diff)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		code     string
+		expected string
+	}{
+		{"frobnicate", "Main.vm:1: unknown command frobnicate"},
+		{"add 1", "Main.vm:1: add expects 0 arguments, got 1"},
+		{"label", "Main.vm:1: label expects 1 argument, got 0"},
+		{"push constant", "Main.vm:1: push expects 2 arguments, got 1"},
+		{"push constant x", "Main.vm:1: invalid number x"},
+		{"push constant 32768", "Main.vm:1: invalid number 32768"},
+		{"\npop constant 1", "Main.vm:2: cannot pop to the constant segment"},
+		{"push pointer 2", "Main.vm:1: pointer index 2 out of range"},
+		{"push temp 8", "Main.vm:1: temp index 8 out of range"},
+		{"push nowhere 1", "Main.vm:1: unknown segment nowhere"},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(File{Name: "Main", Code: test.code})
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("expected error %q, got %v", test.expected, err)
+		}
+	}
+}